@@ -0,0 +1,47 @@
+package account
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrNotFound           = errors.New("account not found")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrWeakPassword       = errors.New("password too short")
+)
+
+// MinPasswordLength is the shortest password an account can be created with.
+const MinPasswordLength = 6
+
+// Account is a persisted player identity.
+type Account struct {
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"passwordHash"`
+	Level        int       `json:"level"`
+	XP           int       `json:"xp"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func (this *Account) copy() *Account {
+	ret := *this
+	return &ret
+}
+
+// Store is implemented by anything able to persist accounts.
+type Store interface {
+	Create(username, password string) (*Account, error)       // Registers a new account, the username has to be unique.
+	Authenticate(username, password string) (*Account, error) // Retrieves an account given its credentials.
+	Get(username string) (*Account, error)                    // Retrieves an account.
+	Credit(username string, xp int) (*Account, error)         // Gives XP to an account, updating its level.
+}
+
+// LevelForXP computes the level reached with a given amount of XP. Each level requires more XP than the previous one.
+func LevelForXP(xp int) int {
+	if xp <= 0 {
+		return 0
+	}
+	return int(math.Sqrt(float64(xp) / 100))
+}
@@ -0,0 +1,130 @@
+package account
+
+import (
+	"encoding/json"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore keeps all accounts in memory, and persists them to a JSON file after each change.
+type FileStore struct {
+	mutex    sync.Mutex
+	path     string
+	accounts map[string]*Account // Accounts by lowercase username.
+}
+
+// NewFileStore is the FileStore default constructor. Existing accounts are loaded from the given file, if any.
+func NewFileStore(path string) (*FileStore, error) {
+	ret := &FileStore{path: path, accounts: make(map[string]*Account)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		ret.accounts[key(account.Username)] = account
+	}
+	return ret, nil
+}
+
+// Usernames are unique regardless of their case.
+func key(username string) string {
+	return strings.ToLower(username)
+}
+
+func (this *FileStore) Create(username, password string) (*Account, error) {
+	if len(password) < MinPasswordLength {
+		return nil, ErrWeakPassword
+	}
+	if _, err := this.Get(username); err == nil {
+		return nil, ErrUsernameTaken
+	}
+	// Hash without holding the lock, other accounts shouldn't wait for it.
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	// Someone else might have taken the username in the meantime.
+	if _, ok := this.accounts[key(username)]; ok {
+		return nil, ErrUsernameTaken
+	}
+	account := &Account{Username: username, PasswordHash: hash, CreatedAt: time.Now()}
+	this.accounts[key(username)] = account
+	if err := this.save(); err != nil {
+		delete(this.accounts, key(username))
+		return nil, err
+	}
+	return account.copy(), nil
+}
+
+func (this *FileStore) Authenticate(username, password string) (*Account, error) {
+	account, err := this.Get(username)
+	if err != nil || bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return account, nil
+}
+
+func (this *FileStore) Get(username string) (*Account, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	account, ok := this.accounts[key(username)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return account.copy(), nil
+}
+
+func (this *FileStore) Credit(username string, xp int) (*Account, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	account, ok := this.accounts[key(username)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	account.XP += xp
+	account.Level = LevelForXP(account.XP)
+	if err := this.save(); err != nil {
+		return nil, err
+	}
+	return account.copy(), nil
+}
+
+// save writes all accounts to a temporary file, then moves it over the previous one.
+func (this *FileStore) save() error {
+	keys := make([]string, 0, len(this.accounts))
+	for k := range this.accounts {
+		keys = append(keys, k)
+	}
+	// Keep the file stable from one save to the next.
+	sort.Strings(keys)
+	accounts := make([]*Account, len(keys))
+	for idx, k := range keys {
+		accounts[idx] = this.accounts[k]
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(this.path), 0700); err != nil {
+		return err
+	}
+	tmp := this.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, this.path)
+}
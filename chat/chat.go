@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MaxLength = 280 // The maximum number of characters of a message.
+
+// Channels messages can be sent to.
+const (
+	ChannelGlobal = "global" // Everyone connected to the server.
+	ChannelCombat = "combat" // Everyone in the same combat, spectators included.
+	ChannelDirect = "direct" // A single player.
+)
+
+var (
+	ErrEmpty    = errors.New("empty message")
+	ErrTooLong  = errors.New("message too long")
+	ErrRejected = errors.New("message rejected")
+)
+
+// IsChannel checks whether a channel exists.
+func IsChannel(channel string) bool {
+	return channel == ChannelGlobal || channel == ChannelCombat || channel == ChannelDirect
+}
+
+// Filter is a hook deciding what can be said. It returns the text to broadcast, or false when the message
+// should not be broadcast at all.
+type Filter func(text string) (string, bool)
+
+// NoFilter lets everything through.
+func NoFilter(text string) (string, bool) { return text, true }
+
+// Clean trims a message, checks its length, and runs it through a filter.
+func Clean(text string, filter Filter) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", ErrEmpty
+	} else if utf8.RuneCountInString(text) > MaxLength {
+		return "", ErrTooLong
+	}
+	if filter == nil {
+		return text, nil
+	}
+	text, ok := filter(text)
+	if !ok {
+		return "", ErrRejected
+	}
+	return text, nil
+}
+
+// NewWordFilter masks a list of words, whatever their case, when they appear as whole words.
+func NewWordFilter(words []string) Filter {
+	banned := make(map[string]bool, len(words))
+	for _, word := range words {
+		banned[strings.ToLower(word)] = true
+	}
+	return func(text string) (string, bool) {
+		runes := []rune(text)
+		for start := 0; start < len(runes); {
+			if !isWordRune(runes[start]) {
+				start++
+				continue
+			}
+			end := start
+			for end < len(runes) && isWordRune(runes[end]) {
+				end++
+			}
+			if banned[strings.ToLower(string(runes[start:end]))] {
+				for idx := start; idx < end; idx++ {
+					runes[idx] = '*'
+				}
+			}
+			start = end
+		}
+		return string(runes), true
+	}
+}
+
+// LoadWordFilter reads the words to mask from a file, one per line.
+func LoadWordFilter(path string) (Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" && !strings.HasPrefix(word, "#") {
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewWordFilter(words), nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
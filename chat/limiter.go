@@ -0,0 +1,46 @@
+package chat
+
+import "time"
+
+// Limiter caps how many messages each sender can send, allowing short bursts.
+type Limiter struct {
+	burst    int           // How many messages can be sent in a row.
+	interval time.Duration // How long it takes to earn the right to send one more message.
+	buckets  map[string]*bucket
+}
+
+// bucket holds the messages a sender can still send.
+type bucket struct {
+	tokens    int
+	updatedAt time.Time
+}
+
+func NewLimiter(burst int, interval time.Duration) *Limiter {
+	return &Limiter{burst: burst, interval: interval, buckets: make(map[string]*bucket)}
+}
+
+// Allow checks whether a sender can send a message now, and counts it if so.
+func (this *Limiter) Allow(sender string, now time.Time) bool {
+	b, ok := this.buckets[sender]
+	if !ok {
+		b = &bucket{tokens: this.burst, updatedAt: now}
+		this.buckets[sender] = b
+	}
+	if earned := int(now.Sub(b.updatedAt) / this.interval); earned > 0 {
+		b.tokens += earned
+		b.updatedAt = b.updatedAt.Add(time.Duration(earned) * this.interval)
+		if b.tokens >= this.burst {
+			b.tokens, b.updatedAt = this.burst, now
+		}
+	}
+	if b.tokens == 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Forget drops what is known about a sender.
+func (this *Limiter) Forget(sender string) {
+	delete(this.buckets, sender)
+}
@@ -1,5 +1,6 @@
 package cbt
 
+import "github.com/hickscorp/communitrix-server/cmd/tx"
 import "github.com/hickscorp/communitrix-server/logic"
 import "github.com/hickscorp/communitrix-server/util"
 
@@ -7,9 +8,24 @@ func Wrap(sub interface{}) *Base {
 	return &Base{Command: sub}
 }
 
-type Base struct{ Command interface{} }
+// Reply wraps a command issued by a client request, so the combat can answer it.
+func Reply(id string, sub interface{}) *Base {
+	return &Base{ID: id, Command: sub}
+}
+
+type Base struct {
+	ID      string // The client-supplied request identifier, if any.
+	Command interface{}
+}
 type AddPlayer struct{ Player interface{} }
 type RemovePlayer struct{ Player interface{} }
+type Resume struct{ Player interface{} }
+type AddSpectator struct{ Player interface{} }
+type Launch struct{ Player interface{} }
+type Ready struct {
+	Player interface{}
+	Ready  bool
+}
 
 type Summarize struct {
 	Ret chan util.MapHelper
@@ -22,12 +38,23 @@ type Start struct {
 }
 
 type StartNewTurn struct{}
+type TurnTimeout struct{}
+type Abort struct {
+	Reason string
+}
+type Drain struct {
+	Reason string
+}
 type PlayTurn struct {
 	Player      interface{}
 	PieceIndex  int
 	Translation *logic.Vector
 	Rotation    *logic.Quaternion
 }
+type Chat struct {
+	Player  interface{}
+	Message tx.ChatMessage
+}
 type Vote struct {
 	Player   interface{}
 	PlayerID string
@@ -1,6 +1,13 @@
 package rx
 
-import "github.com/hickscorp/communitrix-server/i"
+import (
+	"github.com/hickscorp/communitrix-server/account"
+	"github.com/hickscorp/communitrix-server/cmd/tx"
+	"github.com/hickscorp/communitrix-server/gen"
+	"github.com/hickscorp/communitrix-server/i"
+	"github.com/hickscorp/communitrix-server/replay"
+	"time"
+)
 
 func Wrap(player i.Player, sub interface{}) *Base {
 	return &Base{
@@ -11,22 +18,102 @@ func Wrap(player i.Player, sub interface{}) *Base {
 
 type Base struct {
 	Player  i.Player
+	ID      string // The client-supplied request identifier, if any.
 	Command interface{}
 }
 
 type Register struct {
 	Username string
+	Password string
+	Version  int
+}
+type Login struct {
+	Username string
+	Password string
+	Version  int
+}
+type AccountCreated struct {
+	Account *account.Account // The account registered away from the hub loop, unless Err is set.
+	Err     error
+	Version int
+}
+type Authenticated struct {
+	Account *account.Account // The account logged into away from the hub loop, unless Err is set.
+	Err     error
+	Version int
+}
+type Credited struct {
+	Account *account.Account // The account credited away from the hub loop, unless Err is set.
+	Gained  int
+	Err     error
 }
 type Unregister struct{}
+type Resume struct {
+	Token string
+}
+type SessionExpire struct {
+	Deadline time.Time
+}
+
+type ChatSend struct {
+	Channel string
+	To      string // The username of the recipient of a direct message.
+	Text    string
+}
+
+type Shutdown struct {
+	Drain time.Duration // How long running combats are given to finish.
+	Done  chan bool     // Closed once every connection is closed.
+}
 
-type CombatList struct{}
+type MatchmakingJoin struct {
+	Players    int
+	Difficulty string
+}
+type MatchmakingLeave struct{}
+
+type CombatList struct {
+	Running bool
+}
 type CombatCreate struct {
-	MinPlayers int
-	MaxPlayers int
+	MinPlayers    int
+	MaxPlayers    int
+	TurnDuration  time.Duration
+	Countdown     time.Duration // How long the lobby waits once everyone is ready, zero meaning the creator starts it.
+	TimeoutAction string
+	Difficulty    string       // The generation preset to use when no profile is given.
+	Profile       *gen.Profile // How the target and pieces get generated, defaults to the normal preset.
+	Visibility    string
+	Password      string
 }
 type CombatJoin struct {
+	UUID     string
+	Code     string // An invite code, which is enough to find the combat.
+	Password string
+}
+type CombatInvite struct {
+	Username string
+}
+type CombatInviteAnswer struct {
+	Code   string
+	Accept bool
+}
+type CombatInviteRevoke struct {
+	Code string
+}
+type CombatInviteCode struct{}
+type CombatSpectate struct {
 	UUID string
 }
+type CombatReplay struct {
+	ID string
+}
+type ReplayLoaded struct {
+	ID      string
+	Entries []*replay.Entry // The entries loaded away from the hub loop, unless Err is set.
+	Err     error
+}
 type CombatEnd struct {
-	UUID string
+	UUID    string
+	Results []tx.CombatResult
 }
@@ -6,28 +6,84 @@ import (
 )
 
 func Wrap(sub interface{}) *Base {
-	return &Base{reflect.TypeOf(sub).Name(), sub}
+	return &Base{Type: reflect.TypeOf(sub).Name(), Command: sub}
+}
+
+// Reply wraps a command answering a client request, so the client can correlate both.
+func Reply(id string, sub interface{}) *Base {
+	ret := Wrap(sub)
+	ret.ID = id
+	return ret
 }
 
 type Base struct {
-	Type    string      `json:"type"`    // Will hold the name of the command.
-	Command interface{} `json:"command"` // The real command.
+	Type    string      `json:"type"`         // Will hold the name of the command.
+	ID      string      `json:"id,omitempty"` // The identifier of the request this command answers, if any.
+	Command interface{} `json:"command"`      // The real command.
 }
 
 type Error struct {
 	Code   int    `json:"code"`
 	Reason string `json:"reason"`
+	Field  string `json:"field,omitempty"` // The path to the faulty field of the packet, if any.
 }
 type Acknowledgment struct {
 	Serial       string `json:"serial"`
 	Valid        bool   `json:"valid"`
+	Reason       int    `json:"reason"` // A reason code explaining the result.
 	ErrorMessage string `json:"errorMessage"`
+	Penalty      int    `json:"penalty,omitempty"` // Penalty points the acknowledged action incurred.
 }
 type Welcome struct {
-	Message string `json:"message"`
+	Message    string `json:"message"`
+	Version    int    `json:"version"`    // The latest protocol version the server speaks.
+	MinVersion int    `json:"minVersion"` // The oldest protocol version the server speaks.
 }
 type Registered struct {
 	Username string `json:"username"`
+	Version  int    `json:"version"` // The protocol version negotiated for this session.
+	Token    string `json:"token"`   // Allows to resume the session after a disconnection.
+}
+type LoggedIn struct {
+	Username string `json:"username"`
+	Version  int    `json:"version"` // The protocol version negotiated for this session.
+	Token    string `json:"token"`   // Allows to resume the session after a disconnection.
+	Level    int    `json:"level"`
+	XP       int    `json:"xp"`
+}
+type Progress struct {
+	Level  int `json:"level"`
+	XP     int `json:"xp"`
+	Gained int `json:"gained"` // The XP earned during the last combat.
+}
+type Resumed struct {
+	Player interface{} `json:"player"`
+}
+
+type ServerShutdown struct {
+	Reason   string `json:"reason"`
+	Deadline int    `json:"deadline"` // The time left before running combats are aborted, in milliseconds.
+}
+
+type ChatMessage struct {
+	Channel  string `json:"channel"`      // Where the message was sent (global, combat, direct).
+	From     string `json:"from"`         // The username of the sender.
+	FromUUID string `json:"fromUUID"`     // The UUID of the sender.
+	To       string `json:"to,omitempty"` // The username of the recipient of a direct message.
+	Text     string `json:"text"`
+	Time     int64  `json:"time"` // When the message was sent, in milliseconds since the epoch.
+}
+
+type MatchmakingStatus struct {
+	Players    int    `json:"players"`
+	Difficulty string `json:"difficulty"`
+	Position   int    `json:"position"` // Rank among the players waiting with the same preferences.
+	Waiting    int    `json:"waiting"`  // How many players are waiting with the same preferences.
+	Elapsed    int    `json:"elapsed"`  // How long the player has been waiting, in milliseconds.
+}
+type MatchmakingLeft struct{}
+type MatchFound struct {
+	UUID string `json:"uuid"` // The combat the player is joining.
 }
 
 type CombatList struct {
@@ -36,6 +92,38 @@ type CombatList struct {
 type CombatJoin struct {
 	Combat interface{} `json:"combat"` // The combat details.
 }
+type CombatInviteCode struct {
+	UUID string `json:"uuid"` // The combat the code gives access to.
+	Code string `json:"code"` // Lets anyone knowing it join the combat, until revoked.
+}
+type CombatInvitation struct {
+	UUID string `json:"uuid"` // The combat the player is invited to.
+	Code string `json:"code"` // Identifies the invitation when answering it.
+	From string `json:"from"` // The username of the player who sent the invitation.
+}
+type CombatInviteSent struct {
+	Code     string `json:"code"`
+	Username string `json:"username"` // The invited player.
+}
+type CombatInviteDeclined struct {
+	Code     string `json:"code"`
+	Username string `json:"username"` // The player who declined.
+}
+type CombatInviteRevoked struct {
+	Code string `json:"code"`
+}
+type CombatPlayerReady struct {
+	PlayerUUID string `json:"playerUUID"`
+	Ready      bool   `json:"ready"`
+}
+type CombatHost struct {
+	PlayerUUID string `json:"playerUUID"` // The player who can now start the combat.
+}
+type CombatCountdown struct {
+	Duration  int  `json:"duration"`            // The total duration of the countdown, in milliseconds.
+	Remaining int  `json:"remaining"`           // The time left before the combat starts, in milliseconds.
+	Cancelled bool `json:"cancelled,omitempty"` // Whether the countdown stopped because the lobby cannot start anymore.
+}
 type CombatPlayerJoined struct {
 	Player util.MapHelper `json:"player"`
 }
@@ -48,15 +136,55 @@ type CombatStart struct {
 	Units  interface{} `json:"units"`
 	Pieces interface{} `json:"pieces"`
 }
+type CombatSnapshot struct {
+	Combat       interface{} `json:"combat"` // The combat details.
+	Target       interface{} `json:"target"`
+	Units        interface{} `json:"units"`
+	Pieces       interface{} `json:"pieces"`
+	TurnID       int         `json:"turnId"`
+	UnitID       int         `json:"unitId"`
+	PlayedPieces []int       `json:"playedPieces"` // The pieces the player already played.
+}
 type CombatNewTurn struct {
 	TurnID int `json:"turnId"`
 	UnitID int `json:"unitId"`
 }
+type CombatTurnTimer struct {
+	TurnID    int `json:"turnId"`
+	Duration  int `json:"duration"`  // The total duration of a turn, in milliseconds.
+	Remaining int `json:"remaining"` // The time left before the turn ends, in milliseconds.
+}
+type CombatTurnTimeout struct {
+	TurnID      int      `json:"turnId"`
+	PlayerUUIDs []string `json:"playerUUIDs"` // The players who didn't play in time.
+	Action      string   `json:"action"`      // What happened to them (skip, autoplay).
+}
 type CombatPlayerTurn struct {
 	PlayerUUID string      `json:"playerUUID"`
 	PieceID    int         `json:"pieceId"`
 	UnitID     int         `json:"unitId"`
 	Unit       interface{} `json:"unit"`
 }
+type CombatVoteCast struct {
+	VoterUUID  string `json:"voterUUID"`  // The player who voted.
+	PlayerUUID string `json:"playerUUID"` // The player being voted against.
+	Votes      int    `json:"votes"`      // How many votes were cast against this player during this turn.
+	Required   int    `json:"required"`   // How many votes are required for the vote to pass.
+}
+type CombatVoteResult struct {
+	PlayerUUID string `json:"playerUUID"` // The player the vote was against.
+	Outcome    string `json:"outcome"`    // What happened to the player (kick, skip).
+	Votes      int    `json:"votes"`
+}
+type CombatResult struct {
+	Rank       int    `json:"rank"`
+	PlayerUUID string `json:"playerUUID"`
+	Username   string `json:"username"`
+	Score      int    `json:"score"`
+	Penalty    int    `json:"penalty"`
+}
 type CombatEnd struct {
+	Units   interface{}    `json:"units"`            // The score of each unit.
+	Results []CombatResult `json:"results"`          // The players, ranked by score.
+	Replay  string         `json:"replay,omitempty"` // The identifier of the combat replay, if it was recorded.
 }
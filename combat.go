@@ -1,14 +1,34 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"github.com/hickscorp/communitrix-server/cmd/cbt"
 	"github.com/hickscorp/communitrix-server/cmd/tx"
 	"github.com/hickscorp/communitrix-server/gen"
 	"github.com/hickscorp/communitrix-server/i"
 	"github.com/hickscorp/communitrix-server/logic"
+	"github.com/hickscorp/communitrix-server/replay"
 	"github.com/hickscorp/communitrix-server/util"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
+)
+
+const (
+	VoteOutcomeKick = "kick" // A player getting voted against leaves the combat.
+	VoteOutcomeSkip = "skip" // A player getting voted against has his current turn skipped.
+
+	TimeoutActionSkip     = "skip"     // Idle players have their turn skipped.
+	TimeoutActionAutoPlay = "autoplay" // Idle players have a legal move played for them.
+
+	VisibilityPublic   = "public"   // The combat is listed, anyone can join it.
+	VisibilityPrivate  = "private"  // The combat is not listed, only players having an invite code can join it.
+	VisibilityPassword = "password" // The combat is listed, but joining it requires a password.
+
+	turnTimerNotificationInterval = 10 * time.Second // How often players are reminded about the turn deadline.
 )
 
 var (
@@ -25,34 +45,81 @@ func NextCombatUUID() int64 {
 
 // Player is the base struct representing connected entities.
 type Combat struct {
-	uuid                   string              // The combat unique identifier on the server.
-	players                map[string]i.Player // Maintains a list of known players.
-	commandQueue           chan *cbt.Base      // The Combat command queue.
-	minPlayers, maxPlayers int                 // The minimum / maximum number of players that can join.
-	state                  *combatState        // The current combat state.
+	uuid                   string                 // The combat unique identifier on the server.
+	seed                   int64                  // The seed everything random in this combat derives from.
+	profile                *gen.Profile           // How the target and pieces are generated.
+	players                map[string]i.Player    // Maintains a list of known players.
+	spectators             map[string]i.Player    // Players watching this combat without taking part in it.
+	commandQueue           chan *cbt.Base         // The Combat command queue.
+	minPlayers, maxPlayers int                    // The minimum / maximum number of players that can join.
+	voteMajority           float64                // The ratio of other players required for a vote to pass.
+	voteOutcome            string                 // What happens to a player when a vote against him passes.
+	silhouettePolicy       logic.SilhouettePolicy // How cells played outside of the target are handled.
+	turnDuration           time.Duration          // How long players have to play each turn, zero meaning forever.
+	timeoutAction          string                 // What happens to idle players once a turn times out.
+	visibility             string                 // Who can see and join the combat.
+	password               string                 // What players have to give to join a password-protected combat.
+	owner                  string                 // The UUID of the player who created the combat, if any.
+	host                   string                 // The player starting the combat from the lobby, empty meaning it starts once full.
+	ready                  map[string]bool        // Players ready to start, by UUID.
+	countdown              time.Duration          // How long the lobby waits once everyone is ready, zero meaning the host starts the combat.
+	countdownTimer         *time.Timer            // The end of the lobby countdown.
+	countdownEndsAt        time.Time              // When the lobby countdown ends.
+	launching              bool                   // Whether the lobby is closed, the combat being prepared.
+	turnTimer              *time.Timer            // The deadline of the current turn.
+	results                []tx.CombatResult      // The ranked players, once the combat is over.
+	replayPath             string                 // Where the replay of this combat gets recorded, empty meaning nowhere.
+	recorder               *replay.Recorder       // The replay log of this combat, once started.
+	state                  *combatState           // The current combat state.
 }
 
 // This represents the combat state at any point in time.
 type combatState struct {
-	turn          int                     // The current turn ID.
-	target        *logic.Piece            // The objective for all players.
-	units         logic.Units             // State of each unit.
-	pieces        logic.Pieces            // The pieces all players are given.
-	playedPieces  map[string]map[int]bool // Associative player name -> Piece ID -> Boolean.
-	playerIndices map[string]int          // Indices associated to each player.
+	turn          int                        // The current turn ID.
+	target        *logic.Piece               // The objective for all players.
+	units         logic.Units                // State of each unit.
+	pieces        logic.Pieces               // The pieces all players are given.
+	playedPieces  map[string]map[int]bool    // Associative player name -> Piece ID -> Boolean.
+	playerIndices map[string]int             // Indices associated to each player.
+	skippedTurns  map[string]int             // Associative player name -> Number of turns skipped.
+	votes         map[string]map[string]bool // Associative player name -> Voter name -> Boolean, for the current turn.
+	penalties     map[string]int             // Associative player name -> Number of cells played outside of the target.
+	validator     *logic.PlacementValidator  // Checks played pieces against the target.
+	turnEndsAt    time.Time                  // When the current turn times out.
 }
 
 func (this *Combat) UUID() string           { return this.uuid }
 func (this *Combat) Notify(cmd interface{}) { this.commandQueue <- cmd.(*cbt.Base) }
+func (this *Combat) Visibility() string     { return this.visibility }
+func (this *Combat) Owner() string          { return this.owner }
+
+// Admits checks the password required to join the combat, if any.
+func (this *Combat) Admits(password string) bool {
+	if this.visibility != VisibilityPassword {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(this.password)) == 1
+}
 
-func NewCombat(minPlayers, maxPlayers int) *Combat {
+func NewCombat(minPlayers, maxPlayers int, turnDuration time.Duration, timeoutAction string, profile *gen.Profile) *Combat {
 	return &Combat{
-		uuid:         fmt.Sprintf("CBT%d", NextCombatUUID()),
-		players:      make(map[string]i.Player),
-		commandQueue: make(chan *cbt.Base, *config.HubCommandBufferSize),
-		minPlayers:   minPlayers,
-		maxPlayers:   maxPlayers,
-		state:        nil,
+		uuid:             fmt.Sprintf("CBT%d", NextCombatUUID()),
+		seed:             rand.Int63(),
+		profile:          profile,
+		players:          make(map[string]i.Player),
+		spectators:       make(map[string]i.Player),
+		ready:            make(map[string]bool),
+		commandQueue:     make(chan *cbt.Base, *config.HubCommandBufferSize),
+		minPlayers:       minPlayers,
+		maxPlayers:       maxPlayers,
+		voteMajority:     *config.VoteMajority,
+		voteOutcome:      *config.VoteOutcome,
+		silhouettePolicy: config.SilhouettePolicy,
+		turnDuration:     turnDuration,
+		timeoutAction:    timeoutAction,
+		visibility:       VisibilityPublic,
+		replayPath:       *config.ReplayPath,
+		state:            nil,
 	}
 }
 
@@ -65,12 +132,22 @@ func (this *Combat) AsSendable() util.MapHelper {
 		turn = this.state.turn
 	}
 	return util.MapHelper{
-		"uuid":        this.uuid,
-		"minPlayers":  this.minPlayers,
-		"maxPlayers":  this.maxPlayers,
-		"started":     this.state != nil,
-		"currentTurn": turn,
-		"players":     this.sendablePlayers(),
+		"uuid":          this.uuid,
+		"seed":          this.seed,
+		"profile":       this.profile,
+		"minPlayers":    this.minPlayers,
+		"maxPlayers":    this.maxPlayers,
+		"turnDuration":  int(this.turnDuration / time.Second),
+		"timeoutAction": this.timeoutAction,
+		"visibility":    this.visibility,
+		"owner":         this.owner,
+		"host":          this.host,
+		"ready":         this.readyPlayers(),
+		"countdown":     int(this.countdown / time.Second),
+		"started":       this.launching,
+		"currentTurn":   turn,
+		"players":       this.sendablePlayers(),
+		"spectators":    len(this.spectators),
 	}
 }
 func (this *Combat) sendablePlayers() []util.MapHelper {
@@ -92,14 +169,397 @@ func (this *Combat) notifyPlayers(do func(i.Player) *tx.Base, perPlayerNotificat
 		for _, player := range this.players {
 			player.Notify(notif)
 		}
+		this.notifySpectators(notif)
+	}
+}
+
+// notifySpectators sends a notification to everyone watching this combat.
+func (this *Combat) notifySpectators(notif *tx.Base) {
+	for _, spectator := range this.spectators {
+		spectator.Notify(notif)
+	}
+}
+
+// snapshot describes the whole combat state as seen by a player or a spectator.
+func (this *Combat) snapshot(player i.Player, spectator bool) tx.CombatSnapshot {
+	snapshot := tx.CombatSnapshot{Combat: this.AsSendable(), UnitID: -1, PlayedPieces: make([]int, 0)}
+	if this.state == nil || this.state.turn == 0 {
+		return snapshot
+	}
+	// Snapshots are encoded by the player's write loop, while units keep growing.
+	snapshot.Target, snapshot.Units, snapshot.Pieces = this.state.target.Clone(), this.state.units.Clone(), this.state.pieces.Clone()
+	snapshot.TurnID = this.state.turn
+	if spectator {
+		return snapshot
+	}
+	snapshot.UnitID = this.unitIndex(player.UUID())
+	for id := range this.state.playedPieces[player.UUID()] {
+		snapshot.PlayedPieces = append(snapshot.PlayedPieces, id)
+	}
+	sort.Ints(snapshot.PlayedPieces)
+	return snapshot
+}
+
+// release detaches everyone from this combat once it's over.
+func (this *Combat) release() {
+	for _, player := range this.players {
+		player.ForgetCombat(this)
+	}
+	for _, spectator := range this.spectators {
+		spectator.ForgetCombat(this)
+	}
+}
+
+// newCombatState prepares the state of a combat about to start.
+func newCombatState() *combatState {
+	return &combatState{
+		turn:          0,
+		target:        nil,
+		units:         nil,
+		pieces:        nil,
+		playedPieces:  make(map[string]map[int]bool),
+		playerIndices: make(map[string]int),
+		skippedTurns:  make(map[string]int),
+		votes:         make(map[string]map[string]bool),
+		penalties:     make(map[string]int),
+	}
+}
+
+// startRecording opens the replay log of this combat, and writes everything needed to simulate it again.
+func (this *Combat) startRecording() {
+	if this.replayPath == "" {
+		return
+	}
+	recorder, err := replay.NewRecorder(this.replayPath, this.uuid)
+	if err != nil {
+		log.Warning("Unable to record a replay of combat %s: %s", this.uuid, err)
+		return
+	}
+	this.recorder = recorder
+	players := make([]replay.Player, len(this.players))
+	for uuid, index := range this.state.playerIndices {
+		player := this.players[uuid]
+		players[index] = replay.Player{UUID: uuid, Username: player.Username(), Account: player.Account()}
+	}
+	owner := ""
+	if player, ok := this.players[this.owner]; ok {
+		owner = player.Account()
+	}
+	this.record(&replay.Entry{
+		Type:    replay.EntryStart,
+		Seed:    this.seed,
+		Profile: this.profile,
+		Rules: &replay.Rules{
+			VoteMajority:     this.voteMajority,
+			VoteOutcome:      this.voteOutcome,
+			SilhouettePolicy: this.silhouettePolicy,
+			TimeoutAction:    this.timeoutAction,
+		},
+		Players:    players,
+		Target:     this.state.target,
+		Pieces:     this.state.pieces,
+		Units:      this.state.units,
+		Visibility: this.visibility,
+		Owner:      owner,
+	})
+}
+
+// record appends an entry to the replay log of this combat, if it is being recorded.
+func (this *Combat) record(entry *replay.Entry) {
+	if this.recorder == nil {
+		return
+	}
+	entry.Turn = this.state.turn
+	if err := this.recorder.Record(entry); err != nil {
+		log.Warning("Unable to record a replay of combat %s, giving up: %s", this.uuid, err)
+		this.stopRecording()
+	}
+}
+
+// stopRecording closes the replay log of this combat, if any.
+func (this *Combat) stopRecording() {
+	if this.recorder != nil {
+		this.recorder.Close()
+		this.recorder = nil
+	}
+}
+
+// replayID gives the identifier of the replay being recorded, if any.
+func (this *Combat) replayID() string {
+	if this.recorder == nil {
+		return ""
+	}
+	return this.recorder.ID()
+}
+
+// hasPlayedTurn checks whether a player is done with the current turn, either by playing or by having it skipped.
+func (this *combatState) hasPlayedTurn(uuid string) bool {
+	return len(this.playedPieces[uuid])+this.skippedTurns[uuid] >= this.turn
+}
+
+// requiredVotes computes how many of the other players have to vote against a player for the vote to pass.
+func (this *Combat) requiredVotes() int {
+	voters := len(this.players) - 1
+	required := int(math.Floor(float64(voters)*this.voteMajority)) + 1
+	if required > voters {
+		required = voters
+	}
+	return required
+}
+
+// unitIndex gives the index of the unit a player is playing on during the current turn.
+func (this *Combat) unitIndex(uuid string) int {
+	return (this.state.playerIndices[uuid] + this.state.turn) % len(this.state.units)
+}
+
+// playPiece merges an already validated piece into the unit a player is playing on, and tells everyone about it.
+func (this *Combat) playPiece(uuid string, pieceIndex int, piece *logic.Piece) {
+	unitId := this.unitIndex(uuid)
+	unit := this.state.units[unitId]
+	// Register the piece as played for this player.
+	this.state.playedPieces[uuid][pieceIndex] = true
+	// Merge the played piece into the current unit.
+	for _, c := range piece.Content {
+		unit.AddCell(c)
+	}
+	// Clean up the unit.
+	unit.CleanUp()
+	// Keep track of the played piece ID within this unit.
+	ids := unit.Moves[uuid]
+	if ids == nil {
+		ids = make([]int, 0)
+	}
+	ids = append(ids, pieceIndex)
+	unit.Moves[uuid] = ids
+
+	// Notifications are encoded by each player's write loop, while the unit keeps growing.
+	sent := unit.Clone()
+	playerMoveNotif := func(i.Player) *tx.Base {
+		return tx.Wrap(tx.CombatPlayerTurn{
+			PlayerUUID: uuid,
+			PieceID:    pieceIndex,
+			UnitID:     unitId,
+			Unit:       sent,
+		})
+	}
+	this.notifyPlayers(playerMoveNotif, false)
+}
+
+// autoPlay looks for any legal move for a player, and plays it. It returns false when no legal move could be found.
+func (this *Combat) autoPlay(uuid string) bool {
+	unit := this.state.units[this.unitIndex(uuid)]
+	size := this.state.target.Size
+	for pieceIndex, piece := range this.state.pieces {
+		if this.state.playedPieces[uuid][pieceIndex] {
+			continue
+		}
+		t := logic.NewVectorFromValues(0, 0, 0)
+		for t.X = -size.X; t.X <= size.X; t.X++ {
+			for t.Y = -size.Y; t.Y <= size.Y; t.Y++ {
+				for t.Z = -size.Z; t.Z <= size.Z; t.Z++ {
+					moved := piece.Clone().Translate(t)
+					placement := this.state.validator.Validate(unit.Piece, moved)
+					if !placement.IsValid() {
+						continue
+					}
+					log.Debug("Auto-playing piece %d with translation %v for player %s.", pieceIndex, t, uuid)
+					this.state.penalties[uuid] += placement.Penalty
+					this.playPiece(uuid, pieceIndex, moved)
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// startTurnTimer arms the deadline of the current turn, and tells everyone about it.
+func (this *Combat) startTurnTimer() {
+	this.stopTurnTimer()
+	if this.turnDuration <= 0 {
+		return
+	}
+	this.turnTimer = time.NewTimer(this.turnDuration)
+	this.state.turnEndsAt = time.Now().Add(this.turnDuration)
+	this.notifyTurnTimer()
+}
+
+// stopTurnTimer disarms the deadline of the current turn, if any.
+func (this *Combat) stopTurnTimer() {
+	if this.turnTimer != nil {
+		this.turnTimer.Stop()
+		this.turnTimer = nil
+	}
+}
+
+// notifyTurnTimer tells everyone how much time is left before the current turn ends.
+func (this *Combat) notifyTurnTimer() {
+	remaining := this.state.turnEndsAt.Sub(time.Now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	timerNotif := func(i.Player) *tx.Base {
+		return tx.Wrap(tx.CombatTurnTimer{
+			TurnID:    this.state.turn,
+			Duration:  int(this.turnDuration / time.Millisecond),
+			Remaining: int(remaining / time.Millisecond),
+		})
+	}
+	this.notifyPlayers(timerNotif, false)
+}
+
+// onTurnTimeout skips or auto-plays the turn of every player who hasn't played yet. It returns true when the last turn was just played.
+func (this *Combat) onTurnTimeout() bool {
+	idle := make([]string, 0)
+	for uuid := range this.state.playedPieces {
+		if !this.state.hasPlayedTurn(uuid) {
+			idle = append(idle, uuid)
+		}
+	}
+	log.Debug("Turn %d of combat %s timed out, %d players idle.", this.state.turn, this.uuid, len(idle))
+	timeoutNotif := func(i.Player) *tx.Base {
+		return tx.Wrap(tx.CombatTurnTimeout{
+			TurnID:      this.state.turn,
+			PlayerUUIDs: idle,
+			Action:      this.timeoutAction,
+		})
+	}
+	this.notifyPlayers(timeoutNotif, false)
+	for _, uuid := range idle {
+		if this.timeoutAction == TimeoutActionAutoPlay && this.autoPlay(uuid) {
+			continue
+		}
+		this.state.skippedTurns[uuid]++
+	}
+	return this.nextTurn()
+}
+
+// nextTurn moves on to the next turn whenever all players are done with the current one. It returns true when the last turn was just played.
+func (this *Combat) nextTurn() bool {
+	for uuid := range this.state.playedPieces {
+		if !this.state.hasPlayedTurn(uuid) {
+			return false
+		}
+	}
+	log.Debug("All players have played their turn. Moving on...")
+	this.state.turn++
+	this.state.votes = make(map[string]map[string]bool)
+	for _, player := range this.players {
+		player.Notify(
+			tx.Wrap(tx.CombatNewTurn{
+				TurnID: this.state.turn,
+				UnitID: (this.state.playerIndices[player.UUID()] + this.state.turn) % len(this.state.units),
+			}))
 	}
+	// Spectators don't play on any unit.
+	this.notifySpectators(tx.Wrap(tx.CombatNewTurn{TurnID: this.state.turn, UnitID: -1}))
+	if this.state.turn > len(this.state.pieces) {
+		log.Warning("LAST TURN WAS JUST PLAYED.")
+		this.stopTurnTimer()
+		this.record(&replay.Entry{Type: replay.EntryEnd})
+		scores, results := this.Score()
+		this.results = results
+		// Notify all other players.
+		replayID := this.replayID()
+		endNotif := func(i.Player) *tx.Base {
+			return tx.Wrap(tx.CombatEnd{Units: scores, Results: results, Replay: replayID})
+		}
+		this.notifyPlayers(endNotif, false)
+		return true
+	}
+	this.startTurnTimer()
+	return false
+}
+
+// removePlayer unregisters a player or a spectator. It returns true when the combat is over.
+func (this *Combat) removePlayer(player i.Player) bool {
+	// Spectators leave silently.
+	if _, ok := this.spectators[player.UUID()]; ok {
+		delete(this.spectators, player.UUID())
+		return false
+	}
+	// Replays go through both the vote kicking a player and the recorded departure.
+	if _, ok := this.players[player.UUID()]; !ok {
+		return false
+	}
+	this.record(&replay.Entry{Type: replay.EntryLeave, PlayerUUID: player.UUID()})
+	delete(this.players, player.UUID())
+	// No one left?
+	if len(this.players) == 0 {
+		log.Warning("There is no one left in combat %s, exiting.", this.uuid)
+		return true
+	}
+	// Notify all other players.
+	leaveNotif := func(i.Player) *tx.Base {
+		return tx.Wrap(tx.CombatPlayerLeft{UUID: player.UUID()})
+	}
+	this.notifyPlayers(leaveNotif, false)
+	// Whoever is left in the lobby might be able to start without this player.
+	if this.state == nil {
+		delete(this.ready, player.UUID())
+		if player.UUID() == this.host {
+			this.passHost()
+		}
+		this.updateCountdown()
+	}
+	// The player leaving might be the last one the current turn was waiting for.
+	if this.state != nil && this.state.turn > 0 {
+		delete(this.state.playedPieces, player.UUID())
+		delete(this.state.skippedTurns, player.UUID())
+		delete(this.state.votes, player.UUID())
+		for _, votes := range this.state.votes {
+			delete(votes, player.UUID())
+		}
+		return this.nextTurn()
+	}
+	return false
 }
 
 func (this *Combat) Run() {
+	// Periodically remind everyone about the time left in the current turn.
+	ticker := time.NewTicker(turnTimerNotificationInterval)
+	defer ticker.Stop()
+	defer this.stopTurnTimer()
+	defer this.stopCountdown()
+	// Whenever the combat is over, nobody should be referencing it anymore.
+	defer this.release()
+	defer this.stopRecording()
 	// Loop.
 	for {
+		// Nil deadlines never fire.
+		var turnDeadline, countdownDeadline <-chan time.Time
+		if this.turnTimer != nil {
+			turnDeadline = this.turnTimer.C
+		}
+		if this.countdownTimer != nil {
+			countdownDeadline = this.countdownTimer.C
+		}
 		// Wait for any event to occur.
 		select {
+		// The current turn deadline was reached.
+		case <-turnDeadline:
+			this.turnTimer = nil
+			this.record(&replay.Entry{Type: replay.EntryTurnTimeout})
+			if this.onTurnTimeout() {
+				return
+			}
+
+		// The lobby countdown is over.
+		case <-countdownDeadline:
+			this.countdownTimer = nil
+			if this.canStart() {
+				this.launch()
+			}
+
+		// Time to remind everyone about the turn deadline.
+		case <-ticker.C:
+			if this.turnTimer != nil {
+				this.notifyTurnTimer()
+			}
+			if this.countdownTimer != nil {
+				this.notifyCountdown()
+			}
+
 		case cmd := <-this.commandQueue:
 			switch sub := cmd.Command.(type) {
 
@@ -110,14 +570,22 @@ func (this *Combat) Run() {
 			// Register a new player.
 			case cbt.AddPlayer:
 				player := sub.Player.(i.Player)
-				if this.state != nil && this.state.turn > 0 {
-					player.Notify(tx.Wrap(tx.Error{
+				if this.launching {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
 						Code:   422,
 						Reason: "This combat has already started, you cannot join it anymore.",
 					}))
 					continue
 				}
-				if _, ok := this.players[player.UUID()]; !ok {
+				_, ok := this.players[player.UUID()]
+				if !ok && len(this.players) >= this.maxPlayers {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "This combat is full.",
+					}))
+					continue
+				}
+				if !ok {
 					// Notify all other players.
 					joinNotif := func(i.Player) *tx.Base {
 						return tx.Wrap(tx.CombatPlayerJoined{
@@ -128,40 +596,137 @@ func (this *Combat) Run() {
 					// Add the originator to our list of players.
 					this.players[player.UUID()] = player
 					// The originator can join.
-					player.Notify(tx.Wrap(tx.CombatJoin{Combat: this.AsSendable()}))
+					player.Notify(tx.Reply(cmd.ID, tx.CombatJoin{Combat: this.AsSendable()}))
+				}
+				// Combats without a host start as soon as they are full, the others wait for everyone to be ready.
+				if this.host != "" {
+					this.updateCountdown()
+				} else if len(this.players) == this.maxPlayers {
+					this.launch()
+				}
+
+			// A player is ready to start, or not anymore.
+			case cbt.Ready:
+				player := sub.Player.(i.Player)
+				if _, ok := this.players[player.UUID()]; !ok {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   403,
+						Reason: "Only players can get ready.",
+					}))
+					continue
+				} else if this.launching {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "This combat has already started.",
+					}))
+					continue
+				}
+				this.ready[player.UUID()] = sub.Ready
+				readyNotif := func(i.Player) *tx.Base {
+					return tx.Wrap(tx.CombatPlayerReady{PlayerUUID: player.UUID(), Ready: sub.Ready})
+				}
+				this.notifyPlayers(readyNotif, false)
+				this.updateCountdown()
+
+			// The host wants to start the combat.
+			case cbt.Launch:
+				player := sub.Player.(i.Player)
+				if player.UUID() != this.host {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   403,
+						Reason: "Only the host can start the combat.",
+					}))
+					continue
+				} else if this.launching {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "This combat has already started.",
+					}))
+					continue
+				} else if len(this.players) < this.minPlayers {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: fmt.Sprintf("This combat requires at least %d players.", this.minPlayers),
+					}))
+					continue
+				} else if !this.canStart() {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "All players must be ready.",
+					}))
+					continue
+				}
+				this.launch()
+
+			// A player came back after losing his connection.
+			case cbt.Resume:
+				player := sub.Player.(i.Player)
+				_, playing := this.players[player.UUID()]
+				_, spectating := this.spectators[player.UUID()]
+				if !playing && !spectating {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "You are not participating this combat anymore.",
+					}))
+					continue
+				}
+				player.Notify(tx.Reply(cmd.ID, this.snapshot(player, spectating)))
+				if this.turnTimer != nil {
+					this.notifyTurnTimer()
+				}
+
+			// Register a new spectator.
+			case cbt.AddSpectator:
+				player := sub.Player.(i.Player)
+				if _, ok := this.players[player.UUID()]; ok {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You cannot watch a combat you are participating.",
+					}))
+					continue
 				}
-				// We reached the correct number of players, start the combat!
-				pCount := len(this.players)
-				if pCount == this.maxPlayers { // It's time to start the combat!
-					this.commandQueue <- cbt.Wrap(cbt.Prepare{})
+				log.Debug("Player %s is now watching combat %s.", player.UUID(), this.uuid)
+				this.spectators[player.UUID()] = player
+				player.Notify(tx.Reply(cmd.ID, this.snapshot(player, true)))
+				if this.turnTimer != nil {
+					this.notifyTurnTimer()
 				}
 
 			// Unregister a player.
 			case cbt.RemovePlayer:
-				player := sub.Player.(i.Player)
-				delete(this.players, player.UUID())
-				// No one left?
-				if len(this.players) == 0 {
-					log.Warning("There is no one left in combat %s, exiting.", this.uuid)
+				if this.removePlayer(sub.Player.(i.Player)) {
 					return
 				}
-				// Notify all other players.
-				leaveNotif := func(i.Player) *tx.Base {
-					return tx.Wrap(tx.CombatPlayerLeft{UUID: player.UUID()})
+
+			// Someone said something in this combat.
+			case cbt.Chat:
+				sender := sub.Player.(i.Player)
+				_, playing := this.players[sender.UUID()]
+				_, spectating := this.spectators[sender.UUID()]
+				if !playing && !spectating {
+					sender.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You are not in this combat anymore.",
+						Field:  "channel",
+					}))
+					continue
 				}
-				this.notifyPlayers(leaveNotif, false)
+				for _, player := range this.players {
+					if player.UUID() != sender.UUID() {
+						player.Notify(tx.Wrap(sub.Message))
+					}
+				}
+				for _, spectator := range this.spectators {
+					if spectator.UUID() != sender.UUID() {
+						spectator.Notify(tx.Wrap(sub.Message))
+					}
+				}
+				sender.Notify(tx.Reply(cmd.ID, sub.Message))
 
 			// Should prepare the combat now.
 			case cbt.Prepare:
 				if this.state == nil {
-					this.state = &combatState{
-						turn:          0,
-						target:        nil,
-						units:         nil,
-						pieces:        nil,
-						playedPieces:  make(map[string]map[int]bool),
-						playerIndices: make(map[string]int),
-					}
+					this.state = newCombatState()
 					// Create players indices mapping.
 					idx := 0
 					for uuid, _ := range this.players {
@@ -183,22 +748,23 @@ func (this *Combat) Run() {
 			case cbt.Start:
 				this.state.turn = 1
 				this.state.target, this.state.pieces, this.state.units = sub.Target, sub.Pieces, sub.Units
-				index := 0
+				this.state.validator = logic.NewPlacementValidator(this.state.target, this.silhouettePolicy)
 				for _, player := range this.players {
 					this.state.playedPieces[player.UUID()] = make(map[int]bool)
-					index++
 				}
 				// Give everyone the combat start notification.
+				target, units, pieces := this.state.target.Clone(), this.state.units.Clone(), this.state.pieces.Clone()
 				startNotif := func(i.Player) *tx.Base {
 					return tx.Wrap(
 						tx.CombatStart{
 							UUID:   this.uuid,
-							Target: this.state.target,
-							Units:  this.state.units,
-							Pieces: this.state.pieces,
+							Target: target,
+							Units:  units,
+							Pieces: pieces,
 						})
 				}
 				this.notifyPlayers(startNotif, false)
+				this.startRecording()
 				// Give everyone informations about the turn.
 				turnNotif := func(p i.Player) *tx.Base {
 					return tx.Wrap(tx.CombatNewTurn{
@@ -207,117 +773,216 @@ func (this *Combat) Run() {
 					})
 				}
 				this.notifyPlayers(turnNotif, true)
+				this.notifySpectators(tx.Wrap(tx.CombatNewTurn{TurnID: this.state.turn, UnitID: -1}))
+				this.startTurnTimer()
 
 			// A new turn has started.
 			case cbt.StartNewTurn:
 
+			// Replays go through turn timeouts when they were recorded.
+			case cbt.TurnTimeout:
+				if this.state != nil && this.state.turn > 0 && this.onTurnTimeout() {
+					return
+				}
+
+			// The combat has to stop right away.
+			case cbt.Abort:
+				log.Warning("Combat %s was aborted: %s", this.uuid, sub.Reason)
+				abortNotif := func(i.Player) *tx.Base {
+					return tx.Wrap(tx.Error{Code: 410, Reason: sub.Reason})
+				}
+				this.notifyPlayers(abortNotif, false)
+				return
+
+			// The server is going away. Combats still in their lobby are aborted, the others are left to finish.
+			case cbt.Drain:
+				if this.state != nil {
+					continue
+				}
+				log.Warning("Combat %s was drained from its lobby: %s", this.uuid, sub.Reason)
+				drainNotif := func(i.Player) *tx.Base {
+					return tx.Wrap(tx.Error{Code: 410, Reason: sub.Reason})
+				}
+				this.notifyPlayers(drainNotif, false)
+				return
+
 			// A player is playing his turn.
 			case cbt.PlayTurn:
 				player := sub.Player.(i.Player)
 				if this.state == nil || this.state.turn == 0 {
 					log.Warning("Client %s is sending turns while the combat hasn't started.", player.UUID())
-					player.Notify(tx.Wrap(tx.Error{
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
 						Code:   422,
 						Reason: "You cannot play a turn while the combat has not started.",
 					}))
 					continue
 				}
-				playedPieces := this.state.playedPieces[player.UUID()]
+				playedPieces, ok := this.state.playedPieces[player.UUID()]
+				if !ok {
+					log.Warning("Client %s is sending turns while not participating this combat.", player.UUID())
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "You cannot play a turn in a combat you are not participating.",
+					}))
+					continue
+				}
+				if sub.PieceIndex < 0 || sub.PieceIndex >= len(this.state.pieces) {
+					log.Warning("Client %s is trying to play an unknown piece %d.", player.UUID(), sub.PieceIndex)
+					player.Notify(tx.Reply(cmd.ID, tx.Acknowledgment{
+						Serial:       "PlayTurn",
+						Valid:        false,
+						Reason:       int(logic.PlacementUnknownPiece),
+						ErrorMessage: logic.PlacementUnknownPiece.Message(),
+					}))
+					continue
+				}
+				if this.state.hasPlayedTurn(player.UUID()) {
+					log.Warning("Client %s is trying to play twice during the same turn.", player.UUID())
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "You already played during this turn.",
+					}))
+					continue
+				}
 				if playedPieces[sub.PieceIndex] == true {
 					log.Warning("Client %s is trying to play a piece he already played.", player.UUID())
-					player.Notify(tx.Wrap(tx.Error{
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
 						Code:   422,
 						Reason: "You cannot play the same piece twice.",
 					}))
 					continue
 				}
 
-				angles := sub.Rotation.ToEulerAngles()
-				if !angles.IsMultipleOf(90) {
-					log.Warning("Wrong rotation detected with Quaternion %v: %v.", sub.Rotation, angles)
-					player.Notify(tx.Wrap(tx.Acknowledgment{
+				orientation, ok := logic.OrientationFromQuaternion(sub.Rotation)
+				if !ok {
+					log.Warning("Wrong rotation detected with Quaternion %v.", sub.Rotation)
+					player.Notify(tx.Reply(cmd.ID, tx.Acknowledgment{
 						Serial:       "PlayTurn",
 						Valid:        false,
-						ErrorMessage: "An invalid rotation was detected. Please play again.",
+						Reason:       int(logic.PlacementInvalidRotation),
+						ErrorMessage: logic.PlacementInvalidRotation.Message(),
 					}))
 					continue
 				}
 
-				piece := this.state.pieces[sub.PieceIndex].Clone().Rotate(sub.Rotation).Translate(sub.Translation)
+				piece := this.state.pieces[sub.PieceIndex].Clone().Orient(orientation).Translate(sub.Translation)
 				log.Debug("Piece %d played with translation %v and rotation %v.", sub.PieceIndex, sub.Translation, sub.Rotation)
 
-				// TODO: Check for collisions here.
-				playerIndex := this.state.playerIndices[player.UUID()]
-				unitId := (playerIndex + this.state.turn) % len(this.state.units)
-				unit := this.state.units[unitId]
-
-				for _, v := range piece.Content {
-					if unit.Content.CollidesWith(v) {
-						unit = nil
-						break
-					}
-				}
-				player.Notify(tx.Wrap(tx.Acknowledgment{
+				// Check for collisions and target bounds.
+				unit := this.state.units[this.unitIndex(player.UUID())]
+				placement := this.state.validator.Validate(unit.Piece, piece)
+				player.Notify(tx.Reply(cmd.ID, tx.Acknowledgment{
 					Serial:       "PlayTurn",
-					Valid:        unit != nil,
-					ErrorMessage: "A collision was detected. Please play again.",
+					Valid:        placement.IsValid(),
+					Reason:       int(placement.Reason),
+					ErrorMessage: placement.Reason.Message(),
+					Penalty:      placement.Penalty,
 				}))
-				if unit == nil {
-					log.Warning("Collision detected")
+				if !placement.IsValid() {
+					log.Warning("Invalid placement detected: %s", placement.Reason.Message())
 					break
 				}
-				// Register the piece as played for this player.
-				playedPieces[sub.PieceIndex] = true
-				// Merge the played piece into the current unit.
-				for _, c := range piece.Content {
-					unit.AddCell(c)
-				}
-				// Clean up the unit.
-				unit.CleanUp()
-				// Keep track of the played piece ID within this unit.
-				ids := unit.Moves[player.UUID()]
-				if ids == nil {
-					ids = make([]int, 0)
-				}
-				ids = append(ids, sub.PieceIndex)
-				unit.Moves[player.UUID()] = ids
-
-				playerMoveNotif := func(i.Player) *tx.Base {
-					return tx.Wrap(tx.CombatPlayerTurn{
-						PlayerUUID: player.UUID(),
-						PieceID:    sub.PieceIndex,
-						UnitID:     unitId,
-						Unit:       unit,
+				this.record(&replay.Entry{
+					Type:        replay.EntryPlayTurn,
+					PlayerUUID:  player.UUID(),
+					PieceIndex:  sub.PieceIndex,
+					Rotation:    sub.Rotation,
+					Translation: sub.Translation,
+				})
+				this.state.penalties[player.UUID()] += placement.Penalty
+				this.playPiece(player.UUID(), sub.PieceIndex, piece)
+
+				// Check whether all players have played the current turn.
+				if this.nextTurn() {
+					return
+				}
+
+			// A player is voting against another player.
+			case cbt.Vote:
+				voter := sub.Player.(i.Player)
+				if this.state == nil || this.state.turn == 0 {
+					log.Warning("Client %s is voting while the combat hasn't started.", voter.UUID())
+					voter.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "You cannot vote while the combat has not started.",
+					}))
+					continue
+				}
+				if _, ok := this.players[voter.UUID()]; !ok {
+					log.Warning("Client %s is voting while not participating this combat.", voter.UUID())
+					voter.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "You cannot vote in a combat you are not participating.",
+					}))
+					continue
+				}
+				target, ok := this.players[sub.PlayerID]
+				if !ok {
+					voter.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "The player you voted against is not participating this combat.",
+					}))
+					continue
+				}
+				if target.UUID() == voter.UUID() {
+					voter.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "You cannot vote against yourself.",
+					}))
+					continue
+				}
+				votes := this.state.votes[target.UUID()]
+				if votes == nil {
+					votes = make(map[string]bool)
+					this.state.votes[target.UUID()] = votes
+				}
+				if votes[voter.UUID()] {
+					voter.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "You already voted against this player during this turn.",
+					}))
+					continue
+				}
+				votes[voter.UUID()] = true
+				this.record(&replay.Entry{Type: replay.EntryVote, PlayerUUID: voter.UUID(), Against: sub.PlayerID})
+				required := this.requiredVotes()
+				log.Debug("Player %s voted against %s (%d / %d).", voter.UUID(), target.UUID(), len(votes), required)
+
+				voteNotif := func(i.Player) *tx.Base {
+					return tx.Wrap(tx.CombatVoteCast{
+						VoterUUID:  voter.UUID(),
+						PlayerUUID: target.UUID(),
+						Votes:      len(votes),
+						Required:   required,
 					})
 				}
-				this.notifyPlayers(playerMoveNotif, false)
+				this.notifyPlayers(voteNotif, false)
+				if len(votes) < required {
+					continue
+				}
 
-				// Check whether all players have played the current turn.
-				allPlayed := true
-				for _, playedPieces := range this.state.playedPieces {
-					if len(playedPieces) < this.state.turn {
-						allPlayed = false
-						break
-					}
+				// The vote passed, apply its outcome.
+				delete(this.state.votes, target.UUID())
+				resultNotif := func(i.Player) *tx.Base {
+					return tx.Wrap(tx.CombatVoteResult{
+						PlayerUUID: target.UUID(),
+						Outcome:    this.voteOutcome,
+						Votes:      len(votes),
+					})
 				}
-				if allPlayed {
-					log.Debug("All players have played their turn. Moving on...")
-					this.state.turn++
-					for _, player := range this.players {
-						player.Notify(
-							tx.Wrap(tx.CombatNewTurn{
-								TurnID: this.state.turn,
-								UnitID: (this.state.playerIndices[player.UUID()] + this.state.turn) % len(this.state.units),
-							}))
+				this.notifyPlayers(resultNotif, false)
+				switch this.voteOutcome {
+				case VoteOutcomeKick:
+					target.ForgetCombat(this)
+					if this.removePlayer(target) {
+						return
 					}
-					if this.state.turn > len(this.state.pieces) {
-						log.Warning("LAST TURN WAS JUST PLAYED.")
-						// Notify all other players.
-						endNotif := func(i.Player) *tx.Base {
-							return tx.Wrap(tx.CombatEnd{})
+				case VoteOutcomeSkip:
+					if !this.state.hasPlayedTurn(target.UUID()) {
+						this.state.skippedTurns[target.UUID()]++
+						if this.nextTurn() {
+							return
 						}
-						this.notifyPlayers(endNotif, false)
-						return
 					}
 				}
 			}
@@ -330,19 +995,15 @@ func (this *Combat) Prepare() (*cbt.Start, bool) {
 
 	// Cache player count.
 	playerCount := len(this.players)
+	// Generation only depends on the combat seed.
+	rng := rand.New(rand.NewSource(this.seed))
 	// Prepare data.
-	target, ok := gen.NewCellularAutomata(&logic.Vector{4, 4, 4}).Run(0.5)
+	target, pieces, ok := this.profile.Generate(rng, config.Polycubes)
 	if !ok {
-		log.Warning("Something went wrong during target generation.")
 		return nil, false
 	}
-	log.Debug("  - Target: Cells %d, Size: %d", target.Size, len(target.Content))
+	log.Debug("  - Target: Size %d, Cells: %d", target.Size, len(target.Content))
 
-	pieces, ok := gen.NewPieceSplitter().Run(target, 8)
-	if !ok {
-		log.Warning("Something went wrong during pieces generation.")
-		return nil, false
-	}
 	units, ok := make(logic.Units, playerCount), true
 	if !ok {
 		log.Warning("Something went wrong during units generation.")
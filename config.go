@@ -1,12 +1,33 @@
 package main
 
-import "github.com/op/go-logging"
+import (
+	"github.com/hickscorp/communitrix-server/chat"
+	"github.com/hickscorp/communitrix-server/gen"
+	"github.com/hickscorp/communitrix-server/logic"
+	"github.com/op/go-logging"
+	"time"
+)
 
 // Config is the main configuration object.
 type Config struct {
 	Port                 *int
+	WebSocketPort        *int
+	WebSocketOrigins     []string
 	HubCommandBufferSize *int
 	ClientSendBufferSize *int
 	Seed                 *int64
+	VoteMajority         *float64
+	VoteOutcome          *string
+	SilhouettePolicy     logic.SilhouettePolicy
+	TurnDuration         *time.Duration
+	TimeoutAction        *string
+	SessionGracePeriod   *time.Duration
+	ShutdownDeadline     *time.Duration
+	AccountsPath         *string
+	ReplayPath           *string
+	Polycubes            *gen.Catalog
+	ChatBurst            *int
+	ChatInterval         *time.Duration
+	ChatFilter           chat.Filter
 	LogLevel             logging.Level
 }
@@ -0,0 +1,140 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hickscorp/communitrix-server/logic"
+	"io/ioutil"
+)
+
+// Shape is a polycube from a catalog, along with all the ways it can be oriented.
+type Shape struct {
+	Name         string
+	Cells        logic.Vectors
+	orientations []logic.Shape // Every distinct rotation of the shape.
+}
+
+// Catalog is a set of shapes pieces can be drawn from.
+type Catalog struct {
+	Shapes []*Shape
+}
+
+// LoadCatalog reads a catalog from a JSON file, listing shapes as {"name": "I3", "cells": [[0, 0, 0], [0, 0, 1], [0, 0, 2]]}.
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		Name  string   `json:"name"`
+		Cells [][3]int `json:"cells"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	ret := &Catalog{Shapes: make([]*Shape, 0, len(entries))}
+	known := map[uint64][]*Shape{}
+	for _, entry := range entries {
+		cells := make(logic.Vectors, len(entry.Cells))
+		for i, c := range entry.Cells {
+			cells[i] = logic.NewVectorFromValues(c[0], c[1], c[2])
+		}
+		shape, err := NewShape(entry.Name, cells)
+		if err != nil {
+			return nil, err
+		}
+		// Two entries having the same shape would skew the odds of drawing it.
+		canonical := shape.orientations[0].Canonical()
+		for _, other := range known[canonical.Hash()] {
+			if other.orientations[0].Canonical().Equal(canonical) {
+				return nil, fmt.Errorf("shapes %s and %s are the same", other.Name, shape.Name)
+			}
+		}
+		known[canonical.Hash()] = append(known[canonical.Hash()], shape)
+		ret.Shapes = append(ret.Shapes, shape)
+	}
+	return ret, nil
+}
+
+// NewShape validates a polycube and computes its orientations.
+func NewShape(name string, cells logic.Vectors) (*Shape, error) {
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("shape %s has no cells", name)
+	}
+	seen := make(map[logic.Vector]bool, len(cells))
+	for _, cell := range cells {
+		if seen[*cell] {
+			return nil, fmt.Errorf("shape %s has the cell %v twice", name, *cell)
+		}
+		seen[*cell] = true
+	}
+	if !isConnected(cells) {
+		return nil, fmt.Errorf("shape %s is not connected", name)
+	}
+	ret := &Shape{Name: name, Cells: cells}
+	// Symmetrical shapes look the same under several orientations, only keep distinct ones.
+	vectors := make([]logic.Vector, len(cells))
+	for i, v := range cells {
+		vectors[i] = *v
+	}
+	shape := logic.NewShape(vectors)
+	for _, orientation := range logic.Orientations() {
+		rotated, known := shape.Orient(orientation), false
+		for _, other := range ret.orientations {
+			known = known || other.Equal(rotated)
+		}
+		if !known {
+			ret.orientations = append(ret.orientations, rotated)
+		}
+	}
+	return ret, nil
+}
+
+// Size gives the number of cells of the shape.
+func (this *Shape) Size() int { return len(this.Cells) }
+
+// within lists the shapes having between min and max cells.
+func (this *Catalog) within(min, max int) []*Shape {
+	ret := make([]*Shape, 0)
+	for _, shape := range this.Shapes {
+		if shape.Size() >= min && shape.Size() <= max {
+			ret = append(ret, shape)
+		}
+	}
+	return ret
+}
+
+// isConnected checks whether cells are all linked together by their faces.
+func isConnected(cells logic.Vectors) bool {
+	set := make(map[logic.Vector]bool, len(cells))
+	for _, c := range cells {
+		set[*c] = true
+	}
+	seen := map[logic.Vector]bool{*cells[0]: true}
+	stack := []logic.Vector{*cells[0]}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, d := range directions {
+			n := logic.Vector{c.X + d.X, c.Y + d.Y, c.Z + d.Z}
+			if set[n] && !seen[n] {
+				seen[n] = true
+				stack = append(stack, n)
+			}
+		}
+	}
+	return len(seen) == len(set)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,99 @@
+package gen
+
+import (
+	"fmt"
+	"github.com/hickscorp/communitrix-server/logic"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// catalogPath is where the catalog shipped with the server lies.
+const catalogPath = "../catalogs/polycubes.json"
+
+// polycubes enumerates every polycube of a given size up to rotation, by growing smaller ones a cell at a time.
+func polycubes(size int) map[string]logic.Shape {
+	ret := map[string]logic.Shape{"": logic.NewShape([]logic.Vector{{0, 0, 0}})}
+	for n := 1; n < size; n++ {
+		grown := make(map[string]logic.Shape)
+		for _, shape := range ret {
+			for _, cell := range shape {
+				for _, d := range directions {
+					v := logic.Vector{cell.X + d.X, cell.Y + d.Y, cell.Z + d.Z}
+					taken := false
+					for _, other := range shape {
+						taken = taken || other == v
+					}
+					if taken {
+						continue
+					}
+					canonical := logic.NewShape(append(append([]logic.Vector{}, shape...), v)).Canonical()
+					grown[fmt.Sprint(canonical)] = canonical
+				}
+			}
+		}
+		ret = grown
+	}
+	return ret
+}
+
+func TestCatalogHoldsEveryPolycube(t *testing.T) {
+	catalog, err := LoadCatalog(catalogPath)
+	if err != nil {
+		t.Fatalf("cannot load the catalog: %s", err)
+	}
+	// Mirror images can't be rotated into each other, so they count as distinct shapes.
+	for size, want := range map[int]int{3: 2, 4: 8, 5: 29} {
+		shapes := catalog.within(size, size)
+		if len(shapes) != want {
+			t.Errorf("the catalog has %d shapes of %d cells, want %d", len(shapes), size, want)
+		}
+		all := polycubes(size)
+		if len(all) != want {
+			t.Fatalf("there are %d polycubes of %d cells, want %d", len(all), size, want)
+		}
+		names := make(map[string]bool)
+		for _, shape := range shapes {
+			if names[shape.Name] {
+				t.Errorf("the name %s is used twice", shape.Name)
+			}
+			names[shape.Name] = true
+			if _, ok := all[fmt.Sprint(shape.orientations[0].Canonical())]; !ok {
+				t.Errorf("shape %s is not a polycube of %d cells", shape.Name, size)
+			}
+		}
+	}
+	if others := len(catalog.Shapes) - len(catalog.within(3, 5)); others != 0 {
+		t.Errorf("the catalog has %d shapes of other sizes", others)
+	}
+}
+
+func TestLoadCatalogRejectsInvalidShapes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases := []struct {
+		name, content string
+	}{
+		{"not json", `[{"name": "I3"`},
+		{"no cells", `[{"name": "X", "cells": []}]`},
+		{"repeated cell", `[{"name": "X", "cells": [[0, 0, 0], [0, 0, 0]]}]`},
+		{"disconnected", `[{"name": "X", "cells": [[0, 0, 0], [0, 0, 2]]}]`},
+		{"rotated duplicate", `[{"name": "L", "cells": [[0, 0, 0], [0, 0, 1], [0, 1, 0]]}, {"name": "J", "cells": [[0, 0, 0], [1, 0, 0], [1, 1, 0]]}]`},
+	}
+	for idx, c := range cases {
+		path := filepath.Join(dir, fmt.Sprintf("%d.json", idx))
+		if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadCatalog(path); err == nil {
+			t.Errorf("%s: loading should have failed", c.name)
+		}
+	}
+	if _, err := LoadCatalog(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file: loading should have failed")
+	}
+}
@@ -5,22 +5,42 @@ import (
 	"github.com/hickscorp/communitrix-server/logic"
 	"github.com/hickscorp/communitrix-server/util"
 	"math/rand"
+	"sort"
 )
 
+// How many times picking an already filled cell is tolerated during a single iteration, before giving up.
+const fillMisses = 1 << 16
+
 type CellularAutomata struct {
+	rng             *rand.Rand
 	size            *logic.Vector
 	result          *array.ContentArray
 	probabilities   *array.ContentArray
 	spreadingFactor float64
+	regions         int
 }
 
-func NewCellularAutomata(size *logic.Vector) *CellularAutomata {
+func NewCellularAutomata(rng *rand.Rand, size *logic.Vector) *CellularAutomata {
 	return &CellularAutomata{
+		rng:             rng,
 		size:            size.Clone(),
 		spreadingFactor: 0.1,
+		regions:         5,
 	}
 }
 
+// WithSpreadingFactor changes how fast the unit grows at each iteration.
+func (this *CellularAutomata) WithSpreadingFactor(spreadingFactor float64) *CellularAutomata {
+	this.spreadingFactor = spreadingFactor
+	return this
+}
+
+// WithRegions changes the number of regions the unit is split into.
+func (this *CellularAutomata) WithRegions(regions int) *CellularAutomata {
+	this.regions = regions
+	return this
+}
+
 // Run creates the unit.
 func (this *CellularAutomata) Run(density float64) (*logic.Piece, bool) {
 	// Normalize inputs.
@@ -29,6 +49,9 @@ func (this *CellularAutomata) Run(density float64) (*logic.Piece, bool) {
 	}
 	// Prepare the total number of blocks to be created.
 	targetSize := int(float64(this.size.Volume()) * density)
+	if targetSize < 1 {
+		return nil, false
+	}
 	// Prepare the result and probabilities array.
 	this.probabilities, this.result = array.NewContentArray(this.size, nil), array.NewContentArray(this.size, nil)
 	// Cache the shape center.
@@ -54,11 +77,14 @@ func (this *CellularAutomata) Run(density float64) (*logic.Piece, bool) {
 		}
 
 		// Prepare iteration.
-		probSum := 0
+		probSum, free := 0, 0
 		groups := map[int]logic.Vectors{}
 		// Look at every single array element.
 		this.probabilities.Each(func(at *logic.Vector, pro int) {
 			if pro != 0 {
+				if this.result.Content[at.X][at.Y][at.Z] == 0 {
+					free++
+				}
 				if locations, ok := groups[pro]; ok {
 					groups[pro] = append(locations, at.Clone())
 				} else {
@@ -67,19 +93,34 @@ func (this *CellularAutomata) Run(density float64) (*logic.Piece, bool) {
 				}
 			}
 		})
-		// Build a dice array.
-		dice := make([]int, 0, probSum)
+		// Build a dice array, in a stable order so the same seed always gives the same result.
+		pros := make([]int, 0, len(groups))
 		for pro := range groups {
+			pros = append(pros, pro)
+		}
+		sort.Ints(pros)
+		dice := make([]int, 0, probSum)
+		for _, pro := range pros {
 			for c := 0; c < pro; c++ {
 				dice = append(dice, pro)
 			}
 		}
-		for i := 0; i < cellsPerIteration; i++ {
-			pro := dice[rand.Intn(probSum)]
+		// Filled cells keep their probabilities, so only the free ones can be added during this iteration.
+		if free == 0 {
+			log.Warning("The unit cannot grow anymore, it has %d cells out of %d.", totalCellsAdded, targetSize)
+			return nil, false
+		} else if cellsPerIteration > free {
+			cellsPerIteration = free
+		}
+		for i, misses := 0, 0; i < cellsPerIteration; {
+			pro := dice[this.rng.Intn(probSum)]
 			locations := groups[pro]
-			location := locations[rand.Intn(len(locations))]
-			if !this.fillCell(location, -1) {
-				i--
+			location := locations[this.rng.Intn(len(locations))]
+			if this.fillCell(location, -1) {
+				i++
+			} else if misses++; misses > fillMisses {
+				log.Warning("Unable to find a free cell to grow the unit, it has %d cells out of %d.", totalCellsAdded+i, targetSize)
+				return nil, false
 			}
 		}
 		// Whenever we reach the target size, stop.
@@ -90,7 +131,7 @@ func (this *CellularAutomata) Run(density float64) (*logic.Piece, bool) {
 	}
 
 	// Split into pieces.
-	this.split(5)
+	this.split(this.regions)
 
 	// Generate the piece.
 	piece := logic.NewPiece(this.size, totalCellsAdded-1)
@@ -120,5 +161,56 @@ func (this *CellularAutomata) fillCell(at *logic.Vector, val int) bool {
 	return true
 }
 
+// split partitions the generated cells into connected regions, valued from 1 to count.
 func (this *CellularAutomata) split(count int) {
+	cells := make(logic.Vectors, 0)
+	this.result.Each(func(at *logic.Vector, val int) {
+		if val != 0 {
+			cells = append(cells, at.Clone())
+		}
+	})
+	if count > len(cells) {
+		count = len(cells)
+	} else if count < 1 {
+		count = 1
+	}
+	// Each region starts from a distinct random cell.
+	frontiers := make([]logic.Vectors, count)
+	for region, idx := range this.rng.Perm(len(cells))[:count] {
+		at := cells[idx]
+		this.result.Content[at.X][at.Y][at.Z] = region + 1
+		frontiers[region] = logic.Vectors{at}
+	}
+	// Regions then take turns growing by a single cell, so they end up roughly balanced.
+	for growing := true; growing; {
+		growing = false
+		for region := range frontiers {
+			if this.grow(region+1, &frontiers[region]) {
+				growing = true
+			}
+		}
+	}
+}
+
+// grow adds a single unassigned cell neighbouring a region to it. It returns false once the region cannot grow anymore.
+func (this *CellularAutomata) grow(value int, frontier *logic.Vectors) bool {
+	for len(*frontier) > 0 {
+		idx := this.rng.Intn(len(*frontier))
+		from := (*frontier)[idx]
+		for _, d := range directions.Clone().Shuffle(this.rng) {
+			at := from.Clone().Translate(d)
+			if at.X < 0 || at.X >= this.size.X || at.Y < 0 || at.Y >= this.size.Y || at.Z < 0 || at.Z >= this.size.Z {
+				continue
+			}
+			if this.result.Content[at.X][at.Y][at.Z] == -1 {
+				this.result.Content[at.X][at.Y][at.Z] = value
+				*frontier = append(*frontier, at)
+				return true
+			}
+		}
+		// This cell is surrounded, forget about it.
+		last := len(*frontier) - 1
+		(*frontier)[idx], *frontier = (*frontier)[last], (*frontier)[:last]
+	}
+	return false
 }
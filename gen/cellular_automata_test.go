@@ -0,0 +1,47 @@
+package gen
+
+import (
+	"github.com/hickscorp/communitrix-server/logic"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestCellularAutomataBoundaries grows units with every extreme profile value, none of them may hang.
+func TestCellularAutomataBoundaries(t *testing.T) {
+	sizes := []*logic.Vector{
+		{MinTargetDimension, MinTargetDimension, MinTargetDimension},
+		{MinTargetDimension, MaxTargetDimension, MinTargetDimension},
+		{MaxTargetDimension, MaxTargetDimension, MaxTargetDimension},
+	}
+	deadline := time.After(time.Minute)
+	for _, size := range sizes {
+		for _, density := range []float64{MinDensity, MaxDensity} {
+			for _, spreadingFactor := range []float64{MinSpreadingFactor, MaxSpreadingFactor} {
+				for seed := int64(1); seed <= seeds(200); seed++ {
+					done := make(chan bool)
+					var unit *logic.Piece
+					go func() {
+						unit, _ = NewCellularAutomata(rand.New(rand.NewSource(seed)), size).WithSpreadingFactor(spreadingFactor).WithRegions(MaxRegions).Run(density)
+						close(done)
+					}()
+					select {
+					case <-done:
+					case <-deadline:
+						t.Fatalf("size %v, density %.2f, spreading factor %.2f, seed %d: generation hangs", size, density, spreadingFactor, seed)
+					}
+					want := int(float64(size.Volume()) * density)
+					if want < 1 {
+						if unit != nil {
+							t.Errorf("size %v, density %.2f, spreading factor %.2f, seed %d: generation should have failed", size, density, spreadingFactor, seed)
+						}
+					} else if unit == nil {
+						t.Errorf("size %v, density %.2f, spreading factor %.2f, seed %d: generation failed", size, density, spreadingFactor, seed)
+					} else if len(unit.Content) != want {
+						t.Errorf("size %v, density %.2f, spreading factor %.2f, seed %d: got %d cells, want %d", size, density, spreadingFactor, seed, len(unit.Content), want)
+					}
+				}
+			}
+		}
+	}
+}
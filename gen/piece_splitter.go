@@ -1,17 +1,28 @@
 package gen
 
 import (
-	"github.com/hickscorp/communitrix-server/array"
 	"github.com/hickscorp/communitrix-server/logic"
 	"math/rand"
-	"sync"
 )
 
+// How many times the splitter starts over from new random seeds before giving up.
+const splitAttempts = 32
+
+// PieceSplitter breaks a piece down into smaller connected pieces, each cell ending up in exactly one of them.
 type PieceSplitter struct {
+	rng          *rand.Rand
+	minPieceSize int
+	maxPieceSize int
+}
+
+func NewPieceSplitter(rng *rand.Rand) *PieceSplitter {
+	return &PieceSplitter{rng: rng, minPieceSize: 1, maxPieceSize: 0}
 }
 
-func NewPieceSplitter() *PieceSplitter {
-	return &PieceSplitter{}
+// WithSizes constrains the number of cells of each piece, a zero maximum meaning unconstrained.
+func (this *PieceSplitter) WithSizes(minPieceSize, maxPieceSize int) *PieceSplitter {
+	this.minPieceSize, this.maxPieceSize = minPieceSize, maxPieceSize
+	return this
 }
 
 func (this *PieceSplitter) Run(piece *logic.Piece, count int) (logic.Pieces, bool) {
@@ -26,121 +37,186 @@ func (this *PieceSplitter) Run(piece *logic.Piece, count int) (logic.Pieces, boo
 		log.Warning("PieceSplitter cannot break down a piece to more than its count of cells.")
 		return nil, false
 	}
+	maxPieceSize := this.maxPieceSize
+	if maxPieceSize == 0 {
+		maxPieceSize = len(piece.Content)
+	}
+	if this.minPieceSize*count > len(piece.Content) || maxPieceSize*count < len(piece.Content) {
+		log.Warning("PieceSplitter cannot break down %d cells into %d pieces of %d to %d cells.", len(piece.Content), count, this.minPieceSize, maxPieceSize)
+		return nil, false
+	}
 
-	// Convert the piece to an array.
-	arr := array.NewContentArrayFromPiece(piece, nil)
-	// Prepare our synchronization objects.
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-
-	ready, queryable := make(chan bool), make(chan *availabilityQuery, count)
-	// Prepare our pieces array to return.
-	pieces := make(logic.Pieces, count)
-
-	// Start as many recursors as required.
-	log.Debug("Spawning %d recursors.", count)
-	for i := 0; i < count; i++ {
-		piece = logic.NewPiece(logic.NewVectorFromValues(0, 0, 0), 0)
-		pieces[i] = piece
-		go RunNewRecursor(ready, wg, i+1, arr.Size, queryable)
-	}
-
-	// This will hold the number of recursors that are ready.
-	allReady := false
-	readyCount := 0
-	done := 0
-	var onNilSignal func(q *availabilityQuery)
-	// When all our recursors become ready, this closure will handle nil signals.
-	onNilWhenReady := func(q *availabilityQuery) {
-		done++
-		log.Debug("  - Recursor #%d ( %d / %d ) is done.", q.ID, done, count)
-	}
-	// As long as our recursors are not all ready, this closure will handle nil signals.
-	onNilWhenNotReady := func(q *availabilityQuery) {
-		if readyCount++; readyCount == count {
-			allReady = true
-			onNilSignal = onNilWhenReady
-			wg.Done()
+	split := newSplit(piece.Content, count, this.minPieceSize, maxPieceSize)
+	for attempt := 1; attempt <= splitAttempts; attempt++ {
+		if split.run(this.rng) {
+			return split.pieces(), true
 		}
+		log.Debug("Piece split attempt %d / %d failed.", attempt, splitAttempts)
 	}
-	onNilSignal = onNilWhenNotReady
-
-	// Now that we have everything ready and running, start the synching mechanism.
-	for done = 0; done < count; {
-		select {
-		// A recursor is asking for availability of a cell.
-		case q := <-queryable:
-			// A recursor gave us a nil signal.
-			if q.At == nil {
-				onNilSignal(q)
-				continue
-			}
-			free := arr.Content[q.At.X][q.At.Y][q.At.Z] == -1
-			if free {
-				arr.Content[q.At.X][q.At.Y][q.At.Z] = q.ID
-				pieces[q.ID-1].AddCell(logic.NewCellFromValues(q.At.X, q.At.Y, q.At.Z, q.ID))
+	log.Warning("PieceSplitter could not satisfy the size constraints.")
+	return nil, false
+}
+
+// split holds the working state of a piece split. Cells are referred to by their index in the original piece.
+type split struct {
+	cells     logic.Cells
+	neighbors [][]int // The indices of the cells touching each cell.
+	count     int     // The number of pieces to make.
+	min, max  int     // The size constraints of each piece.
+	owners    []int   // The piece each cell belongs to, -1 meaning none yet.
+	sizes     []int   // The number of cells of each piece.
+}
+
+func newSplit(cells logic.Cells, count, min, max int) *split {
+	indices := make(map[logic.Vector]int, len(cells))
+	for idx, cell := range cells {
+		indices[*cell.Vector] = idx
+	}
+	neighbors := make([][]int, len(cells))
+	for idx, cell := range cells {
+		for _, d := range directions {
+			if other, ok := indices[logic.Vector{cell.X + d.X, cell.Y + d.Y, cell.Z + d.Z}]; ok {
+				neighbors[idx] = append(neighbors[idx], other)
 			}
-			q.Answer <- free
 		}
 	}
-	return pieces.CleanUp(), true
+	return &split{cells: cells, neighbors: neighbors, count: count, min: min, max: max}
 }
 
-type availabilityQuery struct {
-	ID     int
-	Answer chan bool
-	At     *logic.Vector
+// run attempts a split from random seeds, returning whether all constraints are satisfied.
+func (this *split) run(rng *rand.Rand) bool {
+	this.owners, this.sizes = make([]int, len(this.cells)), make([]int, this.count)
+	for idx := range this.owners {
+		this.owners[idx] = -1
+	}
+	for p, idx := range rng.Perm(len(this.cells))[:this.count] {
+		this.owners[idx], this.sizes[p] = p, 1
+	}
+	// Grow pieces one cell at a time, always the smallest one first. Pieces reaching the maximum size
+	// only keep growing when nothing else can, so every reachable cell gets assigned.
+	for assigned := this.count; assigned < len(this.cells); assigned++ {
+		grown := this.grow(rng, true) || this.grow(rng, false)
+		if !grown {
+			return false
+		}
+	}
+	return this.rebalance()
 }
 
-func RunNewRecursor(ready chan bool, start *sync.WaitGroup, id int, bounds *logic.Vector, queryChan chan *availabilityQuery) {
-	// Prepare a unique query object for this recursor.
-	query := &availabilityQuery{id, make(chan bool), nil}
-	// Prepare a function for signaling a nil event. First one means ready, second one means we're done.
-	signalNil := func() {
-		query.At = nil
-		queryChan <- query
-	}
-	// Get rid of the answer channel whenever this recursor exits. Also signal we're done.
-	defer func() {
-		close(query.Answer)
-		signalNil()
-	}()
-
-	// Create a querying closure.
-	queryFree := func(at *logic.Vector) bool {
-		// No need to look in the content array if we're already out of bounds.
-		if at.X < 0 || at.X >= bounds.X || at.Y < 0 || at.Y >= bounds.Y || at.Z < 0 || at.Z >= bounds.Z {
-			return false
+// grow adds an unassigned cell to the smallest piece able to take one.
+func (this *split) grow(rng *rand.Rand, capped bool) bool {
+	best, candidates := -1, []int(nil)
+	for p := 0; p < this.count; p++ {
+		if (capped && this.sizes[p] >= this.max) || (best != -1 && this.sizes[p] >= this.sizes[best]) {
+			continue
+		}
+		if free := this.frontier(p); len(free) > 0 {
+			best, candidates = p, free
+		}
+	}
+	if best == -1 {
+		return false
+	}
+	this.owners[candidates[rng.Intn(len(candidates))]] = best
+	this.sizes[best]++
+	return true
+}
+
+// frontier lists the unassigned cells touching a piece.
+func (this *split) frontier(p int) []int {
+	ret := make([]int, 0)
+	seen := make(map[int]bool)
+	for idx, owner := range this.owners {
+		if owner != p {
+			continue
 		}
-		// Put the query position in the query object, and send it.
-		query.At = at
-		queryChan <- query
-		// Wait for the answer, return it.
-		return <-query.Answer
-	}
-
-	// Create our recursive closure.
-	var recurse func(*logic.Vector)
-	recurse = func(at *logic.Vector) {
-		dir := directions.Clone().Shuffle()
-		pos := logic.NewVectorFromValues(0, 0, 0)
-		for _, d := range dir {
-			if queryFree(pos.FromVector(at).Translate(d)) {
-				recurse(pos)
+		for _, other := range this.neighbors[idx] {
+			if this.owners[other] == -1 && !seen[other] {
+				seen[other] = true
+				ret = append(ret, other)
 			}
 		}
 	}
-	// Find a random starting point.
-	startAt := logic.NewVectorFromValues(0, 0, 0)
-	for {
-		startAt.X, startAt.Y, startAt.Z = rand.Intn(bounds.X), rand.Intn(bounds.Y), rand.Intn(bounds.Z)
-		if queryFree(startAt) {
+	return ret
+}
+
+// rebalance moves cells across piece borders until all pieces satisfy the size constraints.
+func (this *split) rebalance() bool {
+	for moves := 0; moves < len(this.cells)*this.count; moves++ {
+		moved := false
+		for idx, owner := range this.owners {
+			for _, other := range this.neighbors[idx] {
+				to := this.owners[other]
+				if to == owner {
+					continue
+				}
+				// Only move cells from a piece breaking the constraints, or to one breaking them.
+				tooBig, tooSmall := this.sizes[owner] > this.max, this.sizes[to] < this.min
+				if (!tooBig && !tooSmall) || this.sizes[owner]-1 < this.min || this.sizes[to]+1 > this.max || !this.canGive(owner, idx) {
+					continue
+				}
+				this.owners[idx] = to
+				this.sizes[owner]--
+				this.sizes[to]++
+				moved = true
+				break
+			}
+			if moved {
+				break
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return this.satisfied()
+}
+
+// canGive checks whether a piece stays connected without one of its cells.
+func (this *split) canGive(p, removed int) bool {
+	start := -1
+	for idx, owner := range this.owners {
+		if owner == p && idx != removed {
+			start = idx
 			break
 		}
 	}
-	// Signal the managing routin that this recursor is ready.
-	signalNil()
-	// Wait for the synch mechanism to tell this to start, then start recursing.
-	start.Wait()
-	recurse(startAt)
+	if start == -1 {
+		return false
+	}
+	seen := map[int]bool{start: true}
+	stack := []int{start}
+	for len(stack) > 0 {
+		idx := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, other := range this.neighbors[idx] {
+			if other != removed && this.owners[other] == p && !seen[other] {
+				seen[other] = true
+				stack = append(stack, other)
+			}
+		}
+	}
+	return len(seen) == this.sizes[p]-1
+}
+
+// satisfied checks that every piece respects the size constraints.
+func (this *split) satisfied() bool {
+	for _, size := range this.sizes {
+		if size < this.min || size > this.max {
+			return false
+		}
+	}
+	return true
+}
+
+// pieces builds the resulting pieces. Cells keep their original value.
+func (this *split) pieces() logic.Pieces {
+	ret := make(logic.Pieces, this.count)
+	for p := range ret {
+		ret[p] = logic.NewPiece(logic.NewVectorFromValues(0, 0, 0), this.sizes[p])
+	}
+	for idx, owner := range this.owners {
+		ret[owner].AddCell(this.cells[idx].Clone())
+	}
+	return ret.CleanUp()
 }
@@ -0,0 +1,201 @@
+package gen
+
+import (
+	"github.com/hickscorp/communitrix-server/logic"
+	"github.com/op/go-logging"
+	"math/rand"
+	"testing"
+)
+
+func init() {
+	// Failed attempts are expected here, and logged at length.
+	logging.SetLevel(logging.CRITICAL, "communitrix")
+}
+
+// blob grows a random face-connected piece. Each cell gets its own value, so it can be found again once split.
+func blob(rng *rand.Rand, size int) *logic.Piece {
+	piece := logic.NewPiece(logic.NewVectorFromValues(0, 0, 0), size)
+	piece.AddCell(logic.NewCellFromValues(0, 0, 0, 0))
+	taken := map[logic.Vector]bool{logic.Vector{0, 0, 0}: true}
+	for len(piece.Content) < size {
+		cell := piece.Content[rng.Intn(len(piece.Content))]
+		d := directions[rng.Intn(len(directions))]
+		v := logic.Vector{cell.X + d.X, cell.Y + d.Y, cell.Z + d.Z}
+		if taken[v] {
+			continue
+		}
+		taken[v] = true
+		piece.AddCell(logic.NewCellFromValues(v.X, v.Y, v.Z, len(piece.Content)))
+	}
+	return piece
+}
+
+// tiledBlob grows a random face-connected piece out of count connected parts of min to max cells, so that it can
+// always be split under those constraints. Each cell gets its own value, so it can be found again once split.
+// Parts walled in by the others before reaching their size give nil.
+func tiledBlob(rng *rand.Rand, count, min, max int) *logic.Piece {
+	piece := logic.NewPiece(logic.NewVectorFromValues(0, 0, 0), count*max)
+	taken := make(map[logic.Vector]bool)
+	// free lists the untaken positions touching some cells.
+	free := func(cells logic.Cells) []logic.Vector {
+		ret := make([]logic.Vector, 0)
+		for _, cell := range cells {
+			for _, d := range directions {
+				if v := (logic.Vector{cell.X + d.X, cell.Y + d.Y, cell.Z + d.Z}); !taken[v] {
+					ret = append(ret, v)
+				}
+			}
+		}
+		return ret
+	}
+	for part := 0; part < count; part++ {
+		start := logic.Vector{0, 0, 0}
+		if part > 0 {
+			around := free(piece.Content)
+			start = around[rng.Intn(len(around))]
+		}
+		cells := logic.Cells{logic.NewCellFromValues(start.X, start.Y, start.Z, len(piece.Content))}
+		taken[start] = true
+		piece.AddCell(cells[0])
+		for size := min + rng.Intn(max-min+1); len(cells) < size; {
+			around := free(cells)
+			if len(around) == 0 {
+				return nil
+			}
+			v := around[rng.Intn(len(around))]
+			cell := logic.NewCellFromValues(v.X, v.Y, v.Z, len(piece.Content))
+			taken[v] = true
+			cells = append(cells, cell)
+			piece.AddCell(cell)
+		}
+	}
+	return piece
+}
+
+// cellsConnected checks that cells form a single group, each one touching another by a face.
+func cellsConnected(cells logic.Cells) bool {
+	left := make(map[logic.Vector]bool, len(cells))
+	for _, cell := range cells {
+		left[*cell.Vector] = true
+	}
+	stack := []logic.Vector{*cells[0].Vector}
+	delete(left, stack[0])
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, d := range directions {
+			n := logic.Vector{v.X + d.X, v.Y + d.Y, v.Z + d.Z}
+			if left[n] {
+				delete(left, n)
+				stack = append(stack, n)
+			}
+		}
+	}
+	return len(left) == 0
+}
+
+// seeds gives how many random cases a test runs, fewer in short mode.
+func seeds(count int64) int64 {
+	if testing.Short() {
+		return count / 10
+	}
+	return count
+}
+
+func TestPieceSplitterConstraints(t *testing.T) {
+	// Splits are built greedily, and the tightest constraints can defeat every attempt even though a split exists.
+	// Those are tolerated as long as they stay rare, whatever comes out must be valid though.
+	runs, failures := 0, 0
+	for seed := int64(1); seed <= seeds(5000); seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		count := 2 + rng.Intn(10)
+		min := 1 + rng.Intn(3)
+		max := min + 1 + rng.Intn(5)
+		piece := tiledBlob(rng, count, min, max)
+		if piece == nil || len(piece.Content) <= count {
+			// The splitter never breaks a piece down into single cells.
+			continue
+		}
+		size := len(piece.Content)
+
+		runs++
+		pieces, ok := NewPieceSplitter(rng).WithSizes(min, max).Run(piece, count)
+		if !ok {
+			t.Logf("seed %d: could not split %d cells into %d pieces of %d to %d cells", seed, size, count, min, max)
+			failures++
+			continue
+		}
+		if len(pieces) != count {
+			t.Errorf("seed %d: got %d pieces, want %d", seed, len(pieces), count)
+		}
+		seen := make([]int, size)
+		for idx, p := range pieces {
+			if len(p.Content) < min || len(p.Content) > max {
+				t.Errorf("seed %d: piece %d has %d cells, want %d to %d", seed, idx, len(p.Content), min, max)
+			}
+			if !cellsConnected(p.Content) {
+				t.Errorf("seed %d: piece %d is not connected", seed, idx)
+			}
+			for _, cell := range p.Content {
+				seen[cell.Value]++
+			}
+		}
+		for value, times := range seen {
+			if times != 1 {
+				t.Errorf("seed %d: cell %d was assigned %d times", seed, value, times)
+			}
+		}
+	}
+	if failures*50 > runs {
+		t.Errorf("gave up on %d splits out of %d", failures, runs)
+	}
+}
+
+func TestPieceSplitterIsDeterministic(t *testing.T) {
+	for seed := int64(1); seed <= seeds(2000); seed++ {
+		piece := blob(rand.New(rand.NewSource(seed)), 40)
+		first, ok1 := NewPieceSplitter(rand.New(rand.NewSource(seed))).WithSizes(2, 8).Run(piece.Clone(), 7)
+		second, ok2 := NewPieceSplitter(rand.New(rand.NewSource(seed))).WithSizes(2, 8).Run(piece.Clone(), 7)
+		if ok1 != ok2 || len(first) != len(second) {
+			t.Fatalf("seed %d: runs disagree on their outcome", seed)
+		}
+		for p := range first {
+			if len(first[p].Content) != len(second[p].Content) {
+				t.Fatalf("seed %d: piece %d differs in size", seed, p)
+			}
+			for c, cell := range first[p].Content {
+				if other := second[p].Content[c]; cell.Value != other.Value || *cell.Vector != *other.Vector {
+					t.Fatalf("seed %d: piece %d differs at cell %d", seed, p, c)
+				}
+			}
+		}
+	}
+}
+
+func TestPieceSplitterRejectsImpossibleConstraints(t *testing.T) {
+	// A center cell with an arm on each face: whichever piece doesn't hold the center is a single arm.
+	star := logic.NewPiece(logic.NewVectorFromValues(0, 0, 0), 7)
+	star.AddCell(logic.NewCellFromValues(0, 0, 0, 0))
+	for idx, d := range directions {
+		star.AddCell(logic.NewCellFromValues(d.X, d.Y, d.Z, idx+1))
+	}
+	rng := rand.New(rand.NewSource(1))
+	cases := []struct {
+		name     string
+		piece    *logic.Piece
+		count    int
+		min, max int
+	}{
+		{"no piece", nil, 2, 1, 0},
+		{"no count", star, 0, 1, 0},
+		{"as many pieces as cells", star, 7, 1, 0},
+		{"minimum too large", star, 3, 3, 0},
+		{"maximum too small", star, 2, 1, 3},
+		{"disconnected leftovers", star, 2, 3, 4},
+	}
+	for _, c := range cases {
+		if _, ok := NewPieceSplitter(rng).WithSizes(c.min, c.max).Run(c.piece, c.count); ok {
+			t.Errorf("%s: split should have failed", c.name)
+		}
+	}
+}
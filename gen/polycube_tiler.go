@@ -0,0 +1,179 @@
+package gen
+
+import (
+	"github.com/hickscorp/communitrix-server/logic"
+	"math/rand"
+)
+
+// How many search steps the tiler takes before giving up on a target.
+const tilingBudget = 200000
+
+// PolycubeTiler breaks a piece down into shapes drawn from a catalog, so that they exactly cover it.
+type PolycubeTiler struct {
+	rng          *rand.Rand
+	catalog      *Catalog
+	minPieceSize int
+	maxPieceSize int
+}
+
+func NewPolycubeTiler(rng *rand.Rand, catalog *Catalog) *PolycubeTiler {
+	return &PolycubeTiler{rng: rng, catalog: catalog, minPieceSize: 1, maxPieceSize: 0}
+}
+
+// WithSizes restricts the shapes used to those having a number of cells within bounds, a zero maximum meaning unconstrained.
+func (this *PolycubeTiler) WithSizes(minPieceSize, maxPieceSize int) *PolycubeTiler {
+	this.minPieceSize, this.maxPieceSize = minPieceSize, maxPieceSize
+	return this
+}
+
+func (this *PolycubeTiler) Run(piece *logic.Piece, count int) (logic.Pieces, bool) {
+	if piece == nil || this.catalog == nil {
+		log.Warning("PolycubeTiler cannot run without a piece and a catalog.")
+		return nil, false
+	} else if count <= 0 {
+		log.Warning("PolycubeTiler cannot break down a piece into a negative count of pieces.")
+		return nil, false
+	}
+	maxPieceSize := this.maxPieceSize
+	if maxPieceSize == 0 {
+		maxPieceSize = len(piece.Content)
+	}
+	shapes := this.catalog.within(this.minPieceSize, maxPieceSize)
+	if len(shapes) == 0 {
+		log.Warning("PolycubeTiler has no shape of %d to %d cells in its catalog.", this.minPieceSize, maxPieceSize)
+		return nil, false
+	}
+
+	tiling := newTiling(piece.Content, shapes, count)
+	placements, ok := tiling.solve(this.rng)
+	if !ok {
+		log.Debug("PolycubeTiler could not find an exact cover of %d cells with %d pieces.", len(piece.Content), count)
+		return nil, false
+	}
+	// Pieces keep the value of the target cells they cover.
+	pieces := make(logic.Pieces, len(placements))
+	for p, placement := range placements {
+		pieces[p] = logic.NewPiece(logic.NewVectorFromValues(0, 0, 0), len(placement))
+		for _, idx := range placement {
+			pieces[p].AddCell(piece.Content[idx].Clone())
+		}
+	}
+	return pieces.CleanUp(), true
+}
+
+// tiling holds the working state of an exact cover search. Cells are referred to by their index in the original piece.
+type tiling struct {
+	cells      int
+	count      int     // The number of pieces to make.
+	min, max   int     // The smallest and biggest shapes available.
+	placements [][]int // Every way of placing a shape inside the piece, as lists of cells.
+	covering   [][]int // The placements covering each cell.
+	covered    []bool
+	steps      int
+}
+
+func newTiling(cells logic.Cells, shapes []*Shape, count int) *tiling {
+	indices := make(map[logic.Vector]int, len(cells))
+	for idx, cell := range cells {
+		indices[*cell.Vector] = idx
+	}
+	ret := &tiling{cells: len(cells), count: count, min: shapes[0].Size(), max: shapes[0].Size()}
+	ret.covering = make([][]int, len(cells))
+	ret.covered = make([]bool, len(cells))
+	for _, shape := range shapes {
+		if shape.Size() < ret.min {
+			ret.min = shape.Size()
+		} else if shape.Size() > ret.max {
+			ret.max = shape.Size()
+		}
+		// Anchor the first cell of each orientation on every cell of the piece.
+		for _, orientation := range shape.orientations {
+			for _, anchor := range cells {
+				placement := make([]int, 0, len(orientation))
+				for _, v := range orientation {
+					idx, ok := indices[logic.Vector{anchor.X + v.X - orientation[0].X, anchor.Y + v.Y - orientation[0].Y, anchor.Z + v.Z - orientation[0].Z}]
+					if !ok {
+						break
+					}
+					placement = append(placement, idx)
+				}
+				if len(placement) != len(orientation) {
+					continue
+				}
+				for _, idx := range placement {
+					ret.covering[idx] = append(ret.covering[idx], len(ret.placements))
+				}
+				ret.placements = append(ret.placements, placement)
+			}
+		}
+	}
+	return ret
+}
+
+// solve looks for placements covering every cell exactly once, using exactly as many pieces as required.
+func (this *tiling) solve(rng *rand.Rand) ([][]int, bool) {
+	this.steps = 0
+	chosen := make([][]int, 0, this.count)
+	var search func(remaining int) bool
+	search = func(remaining int) bool {
+		if remaining == 0 {
+			return len(chosen) == this.count
+		}
+		// Make sure the pieces left can still add up to the cells left.
+		left := this.count - len(chosen)
+		if this.steps++; this.steps > tilingBudget || remaining < left*this.min || remaining > left*this.max {
+			return false
+		}
+		// Branch on the cell having the fewest ways of being covered.
+		best, bestOptions := -1, []int(nil)
+		for idx, covered := range this.covered {
+			if covered {
+				continue
+			}
+			options := this.available(idx)
+			if best == -1 || len(options) < len(bestOptions) {
+				best, bestOptions = idx, options
+				if len(options) <= 1 {
+					break
+				}
+			}
+		}
+		rng.Shuffle(len(bestOptions), func(i, j int) { bestOptions[i], bestOptions[j] = bestOptions[j], bestOptions[i] })
+		for _, option := range bestOptions {
+			placement := this.placements[option]
+			this.cover(placement, true)
+			chosen = append(chosen, placement)
+			if search(remaining - len(placement)) {
+				return true
+			}
+			chosen = chosen[:len(chosen)-1]
+			this.cover(placement, false)
+		}
+		return false
+	}
+	if !search(this.cells) {
+		return nil, false
+	}
+	return chosen, true
+}
+
+// available lists the placements covering a cell which don't overlap anything already covered.
+func (this *tiling) available(idx int) []int {
+	ret := make([]int, 0, len(this.covering[idx]))
+	for _, option := range this.covering[idx] {
+		free := true
+		for _, other := range this.placements[option] {
+			free = free && !this.covered[other]
+		}
+		if free {
+			ret = append(ret, option)
+		}
+	}
+	return ret
+}
+
+func (this *tiling) cover(placement []int, covered bool) {
+	for _, idx := range placement {
+		this.covered[idx] = covered
+	}
+}
@@ -0,0 +1,146 @@
+package gen
+
+import (
+	"fmt"
+	"github.com/hickscorp/communitrix-server/logic"
+	"math/rand"
+	"testing"
+)
+
+// box fills a box with cells. Each cell gets its own value, so it can be found again once tiled.
+func box(x, y, z int) *logic.Piece {
+	piece := logic.NewPiece(logic.NewVectorFromValues(x, y, z), x*y*z)
+	for i := 0; i < x; i++ {
+		for j := 0; j < y; j++ {
+			for k := 0; k < z; k++ {
+				piece.AddCell(logic.NewCellFromValues(i, j, k, len(piece.Content)))
+			}
+		}
+	}
+	return piece
+}
+
+// checkTiling makes sure pieces cover every cell of a target exactly once, each one being a catalog shape of the right size.
+func checkTiling(t *testing.T, label string, catalog *Catalog, target *logic.Piece, pieces logic.Pieces, count, min, max int) {
+	if len(pieces) != count {
+		t.Errorf("%s: got %d pieces, want %d", label, len(pieces), count)
+	}
+	known := make(map[string]bool)
+	for _, shape := range catalog.within(min, max) {
+		known[fmt.Sprint(shape.orientations[0].Canonical())] = true
+	}
+	seen := make([]int, len(target.Content))
+	for idx, piece := range pieces {
+		if !known[fmt.Sprint(piece.Canonical())] {
+			t.Errorf("%s: piece %d is not a catalog shape of %d to %d cells", label, idx, min, max)
+		}
+		for _, cell := range piece.Content {
+			seen[cell.Value]++
+		}
+	}
+	for value, times := range seen {
+		if times != 1 {
+			t.Errorf("%s: cell %d was covered %d times", label, value, times)
+		}
+	}
+}
+
+func TestPolycubeTilerCoversExactly(t *testing.T) {
+	catalog, err := LoadCatalog(catalogPath)
+	if err != nil {
+		t.Fatalf("cannot load the catalog: %s", err)
+	}
+	cases := []struct {
+		x, y, z  int
+		count    int
+		min, max int
+	}{
+		{1, 1, 3, 1, 3, 3},
+		{2, 2, 3, 4, 3, 3},
+		{2, 2, 2, 2, 4, 4},
+		{3, 3, 3, 9, 3, 3},
+		{2, 3, 4, 6, 4, 4},
+		{3, 3, 3, 6, 4, 5},
+		{4, 4, 2, 8, 3, 5},
+		{5, 2, 2, 4, 5, 5},
+	}
+	for _, c := range cases {
+		for seed := int64(1); seed <= seeds(50); seed++ {
+			label := fmt.Sprintf("%dx%dx%d box into %d pieces of %d to %d cells, seed %d", c.x, c.y, c.z, c.count, c.min, c.max, seed)
+			target := box(c.x, c.y, c.z)
+			pieces, ok := NewPolycubeTiler(rand.New(rand.NewSource(seed)), catalog).WithSizes(c.min, c.max).Run(target, c.count)
+			if !ok {
+				t.Errorf("%s: no tiling found", label)
+				continue
+			}
+			checkTiling(t, label, catalog, target, pieces, c.count, c.min, c.max)
+		}
+	}
+	// Random targets can't always be tiled, but whatever comes out must be right.
+	tiled := 0
+	for seed := int64(1); seed <= seeds(500); seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		target := blob(rng, 12+rng.Intn(20))
+		count := len(target.Content) / 4
+		pieces, ok := NewPolycubeTiler(rng, catalog).WithSizes(3, 5).Run(target, count)
+		if ok {
+			tiled++
+			checkTiling(t, fmt.Sprintf("blob of %d cells, seed %d", len(target.Content), seed), catalog, target, pieces, count, 3, 5)
+		}
+	}
+	if tiled == 0 {
+		t.Error("no random target could be tiled")
+	}
+}
+
+func TestPolycubeTilerIsDeterministic(t *testing.T) {
+	catalog, err := LoadCatalog(catalogPath)
+	if err != nil {
+		t.Fatalf("cannot load the catalog: %s", err)
+	}
+	for seed := int64(1); seed <= seeds(200); seed++ {
+		target := blob(rand.New(rand.NewSource(seed)), 24)
+		first, ok1 := NewPolycubeTiler(rand.New(rand.NewSource(seed)), catalog).WithSizes(3, 5).Run(target.Clone(), 6)
+		second, ok2 := NewPolycubeTiler(rand.New(rand.NewSource(seed)), catalog).WithSizes(3, 5).Run(target.Clone(), 6)
+		if ok1 != ok2 || len(first) != len(second) {
+			t.Fatalf("seed %d: runs disagree on their outcome", seed)
+		}
+		for p := range first {
+			if !samePiece(first[p], second[p]) {
+				t.Fatalf("seed %d: piece %d differs", seed, p)
+			}
+		}
+	}
+}
+
+func TestPolycubeTilerRejectsImpossibleConstraints(t *testing.T) {
+	catalog, err := LoadCatalog(catalogPath)
+	if err != nil {
+		t.Fatalf("cannot load the catalog: %s", err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	corner := box(2, 2, 1)
+	corner.Content = corner.Content[:3]
+	cases := []struct {
+		name     string
+		catalog  *Catalog
+		piece    *logic.Piece
+		count    int
+		min, max int
+	}{
+		{"no piece", catalog, nil, 1, 3, 3},
+		{"no catalog", nil, box(1, 1, 3), 1, 3, 3},
+		{"no count", catalog, box(1, 1, 3), 0, 3, 3},
+		{"no shape that size", catalog, box(2, 3, 1), 1, 6, 6},
+		{"too few pieces", catalog, box(2, 2, 3), 3, 3, 3},
+		{"too many pieces", catalog, box(2, 2, 3), 5, 3, 3},
+		{"too small for any shape", catalog, box(1, 1, 2), 1, 3, 5},
+		// Straight pieces can't cover a corner.
+		{"no fitting shape", &Catalog{Shapes: catalog.within(3, 3)[:1]}, corner, 1, 3, 3},
+	}
+	for _, c := range cases {
+		if _, ok := NewPolycubeTiler(rng, c.catalog).WithSizes(c.min, c.max).Run(c.piece, c.count); ok {
+			t.Errorf("%s: tiling should have failed", c.name)
+		}
+	}
+}
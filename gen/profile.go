@@ -0,0 +1,139 @@
+package gen
+
+import (
+	"fmt"
+	"github.com/hickscorp/communitrix-server/logic"
+	"math/rand"
+)
+
+// Sane limits for generation profiles. Placement checks hardly depend on the target size anymore, but scoring tries
+// every orientation and translation of each unit: about half a second on a full 8³ target, over ten on a 12³ one.
+// Denser or faster growing targets leave the cellular automata hardly any free cell to pick from.
+const (
+	MinTargetDimension = 2
+	MaxTargetDimension = 8
+	MinDensity         = 0.1
+	MaxDensity         = 0.8
+	MinSpreadingFactor = 0.01
+	MaxSpreadingFactor = 0.5
+	MaxPieceCount      = 32
+	MaxRegions         = 8
+
+	// Piece generation strategies.
+	StrategyCarve     = "carve"     // Pieces are carved randomly out of the target.
+	StrategyPolycubes = "polycubes" // Pieces are polycubes from a catalog, exactly covering the target.
+
+	// DefaultPreset is the preset used when none is specified.
+	DefaultPreset = "normal"
+	// How many targets are generated before giving up on splitting one.
+	generationAttempts = 16
+)
+
+// Profile describes how the target and pieces of a combat are generated.
+type Profile struct {
+	Name            string        `json:"name"`            // The preset this profile is based on, if any.
+	Strategy        string        `json:"strategy"`        // How pieces are made out of the target, carving them by default.
+	Size            *logic.Vector `json:"size"`            // The dimensions of the target.
+	Density         float64       `json:"density"`         // The ratio of the target dimensions filled with cells.
+	SpreadingFactor float64       `json:"spreadingFactor"` // How fast the target grows at each iteration.
+	Regions         int           `json:"regions"`         // How many connected regions, each with its own cell value, the target has.
+	PieceCount      int           `json:"pieceCount"`      // How many pieces players are given.
+	MinPieceSize    int           `json:"minPieceSize"`    // The minimum number of cells of each piece.
+	MaxPieceSize    int           `json:"maxPieceSize"`    // The maximum number of cells of each piece.
+}
+
+var presets = map[string]Profile{
+	"easy":   {Name: "easy", Strategy: StrategyCarve, Size: &logic.Vector{3, 3, 3}, Density: 0.5, SpreadingFactor: 0.1, Regions: 2, PieceCount: 4, MinPieceSize: 1, MaxPieceSize: 6},
+	"normal": {Name: "normal", Strategy: StrategyCarve, Size: &logic.Vector{4, 4, 4}, Density: 0.5, SpreadingFactor: 0.1, Regions: 3, PieceCount: 8, MinPieceSize: 1, MaxPieceSize: 10},
+	"hard":   {Name: "hard", Strategy: StrategyCarve, Size: &logic.Vector{5, 5, 5}, Density: 0.5, SpreadingFactor: 0.15, Regions: 5, PieceCount: 12, MinPieceSize: 2, MaxPieceSize: 12},
+}
+
+// Preset gives a copy of a named profile.
+func Preset(name string) (*Profile, bool) {
+	preset, ok := presets[name]
+	if !ok {
+		return nil, false
+	}
+	preset.Size = preset.Size.Clone()
+	return &preset, true
+}
+
+// Cells gives the number of cells targets generated with this profile have.
+func (this *Profile) Cells() int {
+	return int(float64(this.Size.Volume()) * this.Density)
+}
+
+// Validate checks a profile against sane limits, and makes sure its constraints can be satisfied.
+// The polycube catalog is only required by the polycubes strategy.
+func (this *Profile) Validate(catalog *Catalog) error {
+	if this.Size == nil {
+		return fmt.Errorf("size is required")
+	}
+	for _, d := range []int{this.Size.X, this.Size.Y, this.Size.Z} {
+		if d < MinTargetDimension || d > MaxTargetDimension {
+			return fmt.Errorf("size must be between %d and %d on each axis", MinTargetDimension, MaxTargetDimension)
+		}
+	}
+	if this.Density < MinDensity || this.Density > MaxDensity {
+		return fmt.Errorf("density must be between %.2f and %.2f", MinDensity, MaxDensity)
+	}
+	if this.SpreadingFactor < MinSpreadingFactor || this.SpreadingFactor > MaxSpreadingFactor {
+		return fmt.Errorf("spreadingFactor must be between %.2f and %.2f", MinSpreadingFactor, MaxSpreadingFactor)
+	}
+	cells := this.Cells()
+	if this.Regions < 1 || this.Regions > MaxRegions || this.Regions > cells {
+		return fmt.Errorf("regions must be between 1 and %d, and at most the %d cells of the target", MaxRegions, cells)
+	}
+	if this.PieceCount < 1 || this.PieceCount > MaxPieceCount || this.PieceCount >= cells {
+		return fmt.Errorf("pieceCount must be between 1 and %d, and lower than the %d cells of the target", MaxPieceCount, cells)
+	}
+	if this.MinPieceSize < 1 || this.MaxPieceSize < this.MinPieceSize {
+		return fmt.Errorf("minPieceSize must be at least 1, and maxPieceSize at least minPieceSize")
+	}
+	min, max := this.MinPieceSize, this.MaxPieceSize
+	switch this.Strategy {
+	case "", StrategyCarve:
+	case StrategyPolycubes:
+		if catalog == nil {
+			return fmt.Errorf("no polycube catalog is available")
+		}
+		shapes := catalog.within(min, max)
+		if len(shapes) == 0 {
+			return fmt.Errorf("the polycube catalog has no shape of %d to %d cells", min, max)
+		}
+		// Only the sizes of the shapes actually available matter.
+		min, max = shapes[0].Size(), shapes[0].Size()
+		for _, shape := range shapes {
+			min, max = minInt(min, shape.Size()), maxInt(max, shape.Size())
+		}
+	default:
+		return fmt.Errorf("strategy must be either %s or %s", StrategyCarve, StrategyPolycubes)
+	}
+	if min*this.PieceCount > cells || max*this.PieceCount < cells {
+		return fmt.Errorf("%d pieces of %d to %d cells cannot make up a target of %d cells", this.PieceCount, min, max, cells)
+	}
+	return nil
+}
+
+// Generate creates a target along with the pieces it is split into.
+func (this *Profile) Generate(rng *rand.Rand, catalog *Catalog) (*logic.Piece, logic.Pieces, bool) {
+	for attempt := 1; attempt <= generationAttempts; attempt++ {
+		target, ok := NewCellularAutomata(rng, this.Size).WithSpreadingFactor(this.SpreadingFactor).WithRegions(this.Regions).Run(this.Density)
+		if !ok {
+			log.Debug("Unable to generate a target, attempt %d / %d.", attempt, generationAttempts)
+			continue
+		}
+		var pieces logic.Pieces
+		if this.Strategy == StrategyPolycubes {
+			pieces, ok = NewPolycubeTiler(rng, catalog).WithSizes(this.MinPieceSize, this.MaxPieceSize).Run(target, this.PieceCount)
+		} else {
+			pieces, ok = NewPieceSplitter(rng).WithSizes(this.MinPieceSize, this.MaxPieceSize).Run(target, this.PieceCount)
+		}
+		if ok {
+			return target, pieces, true
+		}
+		log.Debug("Unable to split the generated target, attempt %d / %d.", attempt, generationAttempts)
+	}
+	log.Warning("Unable to generate a target along with pieces satisfying the size constraints.")
+	return nil, nil, false
+}
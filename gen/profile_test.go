@@ -0,0 +1,63 @@
+package gen
+
+import (
+	"github.com/hickscorp/communitrix-server/logic"
+	"math/rand"
+	"testing"
+)
+
+// samePiece checks that two pieces have the same cells, with the same values and in the same order.
+func samePiece(a, b *logic.Piece) bool {
+	if len(a.Content) != len(b.Content) {
+		return false
+	}
+	for idx, cell := range a.Content {
+		if other := b.Content[idx]; cell.Value != other.Value || *cell.Vector != *other.Vector {
+			return false
+		}
+	}
+	return true
+}
+
+func TestProfileGenerateIsDeterministic(t *testing.T) {
+	catalog, err := LoadCatalog("../catalogs/polycubes.json")
+	if err != nil {
+		t.Fatalf("cannot load the polycube catalog: %s", err)
+	}
+	polycubes := Profile{Strategy: StrategyPolycubes, Size: &logic.Vector{4, 4, 4}, Density: 0.5, SpreadingFactor: 0.1, Regions: 3, PieceCount: 8, MinPieceSize: 3, MaxPieceSize: 5}
+	profiles := []*Profile{&polycubes}
+	for _, name := range []string{"easy", "normal", "hard"} {
+		preset, _ := Preset(name)
+		profiles = append(profiles, preset)
+	}
+	for _, profile := range profiles {
+		if err := profile.Validate(catalog); err != nil {
+			t.Fatalf("%s profile: %s", profile.Strategy, err)
+		}
+		generated := 0
+		for seed := int64(1); seed <= seeds(100); seed++ {
+			firstTarget, firstPieces, ok1 := profile.Generate(rand.New(rand.NewSource(seed)), catalog)
+			secondTarget, secondPieces, ok2 := profile.Generate(rand.New(rand.NewSource(seed)), catalog)
+			if ok1 != ok2 {
+				t.Fatalf("%s %s profile, seed %d: runs disagree on their outcome", profile.Name, profile.Strategy, seed)
+			} else if !ok1 {
+				continue
+			}
+			generated++
+			if !samePiece(firstTarget, secondTarget) {
+				t.Fatalf("%s %s profile, seed %d: targets differ", profile.Name, profile.Strategy, seed)
+			}
+			if len(firstPieces) != len(secondPieces) {
+				t.Fatalf("%s %s profile, seed %d: got %d and %d pieces", profile.Name, profile.Strategy, seed, len(firstPieces), len(secondPieces))
+			}
+			for p := range firstPieces {
+				if !samePiece(firstPieces[p], secondPieces[p]) {
+					t.Fatalf("%s %s profile, seed %d: piece %d differs", profile.Name, profile.Strategy, seed, p)
+				}
+			}
+		}
+		if generated == 0 {
+			t.Errorf("%s %s profile: nothing could be generated", profile.Name, profile.Strategy)
+		}
+	}
+}
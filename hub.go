@@ -1,123 +1,961 @@
 package main
 
 import (
+	"fmt"
+	"github.com/hickscorp/communitrix-server/account"
+	"github.com/hickscorp/communitrix-server/chat"
+	"github.com/hickscorp/communitrix-server/cmd/cbt"
 	"github.com/hickscorp/communitrix-server/cmd/rx"
 	"github.com/hickscorp/communitrix-server/cmd/tx"
+	"github.com/hickscorp/communitrix-server/gen"
 	"github.com/hickscorp/communitrix-server/i"
+	"github.com/hickscorp/communitrix-server/replay"
+	"github.com/hickscorp/communitrix-server/transport"
 	"github.com/hickscorp/communitrix-server/util"
-	"net"
+	"net/http"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
+// shutdownFlushDelay is how long queued messages get to reach players before their connection is closed on shutdown.
+const shutdownFlushDelay = 1 * time.Second
+
+// combatSummaryTimeout is how long combats get to describe themselves when listed.
+const combatSummaryTimeout = 2 * time.Second
+
 // Hub structure handles interractions between players.
 type Hub struct {
-	players      map[string]i.Player // Maintains a list of known players.
-	combats      map[string]i.Combat // All existing combats.
-	commandQueue chan *rx.Base       // Registration, unregistration, subscription, unsubscription, broadcasting.
+	players      map[string]i.Player  // Maintains a list of known players.
+	combats      map[string]i.Combat  // All existing combats.
+	sessions     map[string]i.Player  // Known players, by session token.
+	expirations  map[string]time.Time // When disconnected players lose their session, by player UUID.
+	logins       map[string]i.Player  // Players logged into an account, by lowercase username.
+	store        account.Store        // Where player accounts are persisted.
+	matchmaker   *Matchmaker          // Groups players waiting for a combat.
+	invites      *Invites             // The codes allowing players into private combats.
+	chatLimiter  *chat.Limiter        // Prevents players from flooding chat channels.
+	shutdown     *rx.Shutdown         // Set once the server is going away.
+	drainTimer   *time.Timer          // When combats still running get aborted during a shutdown.
+	storeWrites  sync.WaitGroup       // Accounts being written away from the hub loop, waited for before stopping.
+	commandQueue chan *rx.Base        // Registration, unregistration, subscription, unsubscription, broadcasting.
+	// Every connected client, registered or not, so they can all be closed on shutdown.
+	connectionsMutex sync.Mutex
+	connections      map[i.Transport]bool
+	closing          bool // Set once connections are closed, late clients are then turned away.
 }
 
 // NewHub is the Hub default constructor.
-func NewHub() *Hub {
+func NewHub(store account.Store) *Hub {
 	return &Hub{
 		players:      make(map[string]i.Player),
 		combats:      make(map[string]i.Combat),
+		sessions:     make(map[string]i.Player),
+		expirations:  make(map[string]time.Time),
+		logins:       make(map[string]i.Player),
+		store:        store,
+		matchmaker:   NewMatchmaker(),
+		invites:      NewInvites(),
+		chatLimiter:  chat.NewLimiter(*config.ChatBurst, *config.ChatInterval),
 		commandQueue: make(chan *rx.Base, *config.HubCommandBufferSize),
+		connections:  make(map[i.Transport]bool),
+	}
+}
+
+// ServeHTTP is used from the main program as its websocket upgrader.
+func (this *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := transport.Upgrade(w, r, config.WebSocketOrigins)
+	if err != nil {
+		log.Warning("Failed to upgrade websocket client %s: %s", r.RemoteAddr, err)
+		return
 	}
+	this.HandleClient(conn)
 }
 
-// This handler is used from the main program as it's websocket upgrader.
-func (this *Hub) HandleClient(conn net.Conn) {
+// HandleClient runs a connected client until it disconnects, whatever its transport.
+func (this *Hub) HandleClient(conn i.Transport) {
 	log.Debug("New client connected, spawning routine.")
 	time.Sleep(time.Second * 1)
 	// Whenever this method exits, close the connection.
 	defer conn.Close()
+	if !this.track(conn) {
+		return
+	}
+	defer this.untrack(conn)
 	// Store the player information for this connection.
 	StartNewPlayer(this.commandQueue, conn)
 }
 
+// track registers a client connection, unless connections were closed already.
+func (this *Hub) track(conn i.Transport) bool {
+	this.connectionsMutex.Lock()
+	defer this.connectionsMutex.Unlock()
+	if this.closing {
+		return false
+	}
+	this.connections[conn] = true
+	return true
+}
+
+func (this *Hub) untrack(conn i.Transport) {
+	this.connectionsMutex.Lock()
+	defer this.connectionsMutex.Unlock()
+	delete(this.connections, conn)
+}
+
+// forget removes a player from the known players.
+func (this *Hub) forget(player i.Player) {
+	delete(this.players, player.UUID())
+	delete(this.sessions, player.Token())
+	delete(this.expirations, player.UUID())
+	this.matchmaker.Dequeue(player)
+	this.invites.Forget(player)
+	this.chatLimiter.Forget(player.UUID())
+	this.logout(player)
+}
+
+// admit makes a player known under a username, dropping whichever account he was logged into before.
+func (this *Hub) admit(player i.Player, username string) {
+	this.logout(player)
+	player.SetUsername(username)
+	this.players[player.UUID()] = player
+	this.sessions[player.Token()] = player
+}
+
+// logout unbinds a player from his account, if any.
+func (this *Hub) logout(player i.Player) {
+	if this.isLoggedIn(player) {
+		delete(this.logins, strings.ToLower(player.Username()))
+	}
+	player.SetAccount("")
+}
+
+// isLoggedIn checks whether a player is bound to an account.
+func (this *Hub) isLoggedIn(player i.Player) bool {
+	return this.logins[strings.ToLower(player.Username())] == player
+}
+
+// mayWatch tells whether a player can watch a replay. Replays of combats which weren't public are kept for the
+// accounts which took part in them, as guest usernames and player UUIDs can be reused by anyone.
+func (this *Hub) mayWatch(player i.Player, start *replay.Entry) bool {
+	if start.Visibility == "" || start.Visibility == VisibilityPublic {
+		return true
+	}
+	account := player.Account()
+	if account == "" {
+		return false
+	} else if strings.EqualFold(start.Owner, account) {
+		return true
+	}
+	for _, p := range start.Players {
+		if strings.EqualFold(p.Account, account) {
+			return true
+		}
+	}
+	return false
+}
+
+// startCombat runs a combat, and makes sure the hub gets notified when it's over.
+func (this *Hub) startCombat(combat *Combat) {
+	this.combats[combat.UUID()] = combat
+	go func(combat *Combat, ch chan<- *rx.Base) {
+		combat.Run()
+		ch <- rx.Wrap(nil, rx.CombatEnd{UUID: combat.uuid, Results: combat.results})
+	}(combat, this.commandQueue)
+}
+
+// Shutdown stops the hub. Players are told about it, running combats get some time to finish, and all
+// connections are then closed. It blocks until done.
+func (this *Hub) Shutdown(drain time.Duration) {
+	done := make(chan bool)
+	this.commandQueue <- rx.Wrap(nil, rx.Shutdown{Drain: drain, Done: done})
+	<-done
+}
+
+// closeConnections gives queued messages some time to reach players, and then disconnects everyone.
+func (this *Hub) closeConnections() {
+	time.Sleep(shutdownFlushDelay)
+	this.connectionsMutex.Lock()
+	defer this.connectionsMutex.Unlock()
+	this.closing = true
+	for conn := range this.connections {
+		conn.Close()
+	}
+}
+
+// refusedDuringShutdown tells whether a command would make players start something new.
+func refusedDuringShutdown(cmd interface{}) bool {
+	switch sub := cmd.(type) {
+	case rx.MatchmakingJoin, rx.CombatCreate, rx.CombatJoin, rx.CombatSpectate, rx.CombatReplay, rx.ReplayLoaded:
+		return true
+	case rx.CombatInviteAnswer:
+		return sub.Accept
+	}
+	return false
+}
+
+// join lets a player into a combat, given either its UUID or an invite code.
+func (this *Hub) join(player i.Player, requestID string, sub rx.CombatJoin) {
+	var combat i.Combat
+	var inv *invite
+	if sub.Code != "" {
+		inv = this.invites.Find(sub.Code)
+		if inv == nil || (inv.to != nil && inv.to != player) {
+			player.Notify(tx.Reply(requestID, tx.Error{
+				Code:   404,
+				Reason: "This invite code is not valid.",
+				Field:  "code",
+			}))
+			return
+		}
+		combat = inv.combat
+	} else {
+		combat = this.combats[sub.UUID]
+		if combat == nil {
+			log.Warning("The combat %s requested by player %s doesn't exist.", sub.UUID, player.UUID())
+			player.Notify(tx.Reply(requestID, tx.Error{
+				Code:   404,
+				Reason: "Combat was not found.",
+			}))
+			return
+		}
+		if combat.Visibility() == VisibilityPrivate {
+			player.Notify(tx.Reply(requestID, tx.Error{
+				Code:   403,
+				Reason: "This combat can only be joined with an invite code.",
+				Field:  "code",
+			}))
+			return
+		}
+	}
+	if player.IsInCombat() {
+		player.Notify(tx.Reply(requestID, tx.Error{
+			Code:   409,
+			Reason: "You are already in a combat.",
+		}))
+		return
+	}
+	// Invite codes stand for the password.
+	if inv == nil && !combat.Admits(sub.Password) {
+		player.Notify(tx.Reply(requestID, tx.Error{
+			Code:   403,
+			Reason: "This combat requires a valid password.",
+			Field:  "password",
+		}))
+		return
+	}
+	// Invitations sent to a player can only be used once.
+	if inv != nil && inv.to != nil {
+		this.invites.Revoke(inv.code)
+	}
+	this.matchmaker.Dequeue(player)
+	player.JoinCombat(combat, requestID)
+}
+
+// ownedCombat gives the combat a player created and is still part of, if any.
+func (this *Hub) ownedCombat(player i.Player) i.Combat {
+	combat := player.Combat()
+	if combat == nil || combat.Owner() != player.UUID() || this.combats[combat.UUID()] == nil {
+		return nil
+	}
+	return combat
+}
+
+// online finds a connected player by his username.
+func (this *Hub) online(username string) i.Player {
+	for _, player := range this.players {
+		if player.IsConnected() && strings.EqualFold(player.Username(), username) {
+			return player
+		}
+	}
+	return nil
+}
+
+// matchmake starts combats for the groups of queued players the matchmaker could form, and tells the others about their status.
+func (this *Hub) matchmake() {
+	now := time.Now()
+	for _, group := range this.matchmaker.Match(now) {
+		profile, _ := gen.Preset(group[0].difficulty)
+		combat := NewCombat(len(group), len(group), *config.TurnDuration, *config.TimeoutAction, profile)
+		log.Debug("Matched %d players into combat %s.", len(group), combat.UUID())
+		this.startCombat(combat)
+		for _, t := range group {
+			t.player.Notify(tx.Reply(t.requestID, tx.MatchFound{UUID: combat.UUID()}))
+			t.player.JoinCombat(combat, t.requestID)
+		}
+	}
+	for _, t := range this.matchmaker.Stale(now) {
+		t.player.Notify(this.matchmakingStatus(t, now))
+	}
+}
+func (this *Hub) matchmakingStatus(t *ticket, now time.Time) *tx.Base {
+	position, waiting := this.matchmaker.Position(t)
+	return tx.Reply(t.requestID, tx.MatchmakingStatus{
+		Players:    t.players,
+		Difficulty: t.difficulty,
+		Position:   position,
+		Waiting:    waiting,
+		Elapsed:    int(now.Sub(t.since) / time.Millisecond),
+	})
+}
+
+// accountError converts account store errors to client errors.
+func accountError(err error) tx.Error {
+	switch err {
+	case account.ErrUsernameTaken:
+		return tx.Error{Code: 409, Reason: "This username is already taken.", Field: "username"}
+	case account.ErrWeakPassword:
+		return tx.Error{Code: 422, Reason: fmt.Sprintf("Passwords must be at least %d characters long.", account.MinPasswordLength), Field: "password"}
+	case account.ErrInvalidCredentials:
+		return tx.Error{Code: 401, Reason: "Invalid username or password."}
+	}
+	log.Error("Account store failure: %s", err)
+	return tx.Error{Code: 500, Reason: "Something went wrong with your account. Please try again."}
+}
+
+// replayError converts a replay loading error to something players can understand.
+func replayError(err error) tx.Error {
+	switch err {
+	case replay.ErrNotFound:
+		return tx.Error{Code: 404, Reason: "Replay was not found."}
+	case replay.ErrInvalidID:
+		return tx.Error{Code: 422, Reason: "This is not a valid replay identifier.", Field: "replay"}
+	case replay.ErrCorrupted:
+		return tx.Error{Code: 422, Reason: "This replay is corrupted."}
+	}
+	return tx.Error{Code: 500, Reason: "Something went wrong while loading the replay. Please try again."}
+}
+
+// chatError converts a chat message error to something players can understand.
+func chatError(err error) tx.Error {
+	switch err {
+	case chat.ErrEmpty:
+		return tx.Error{Code: 422, Reason: "Your message is empty.", Field: "text"}
+	case chat.ErrTooLong:
+		return tx.Error{Code: 422, Reason: fmt.Sprintf("Messages cannot be longer than %d characters.", chat.MaxLength), Field: "text"}
+	}
+	return tx.Error{Code: 422, Reason: "Your message is not allowed.", Field: "text"}
+}
+
+// combatOptionsError checks the options of a combat to create, and returns the error to send back when they are not acceptable.
+func combatOptionsError(sub rx.CombatCreate) *tx.Error {
+	if sub.TimeoutAction != TimeoutActionSkip && sub.TimeoutAction != TimeoutActionAutoPlay {
+		return &tx.Error{Code: 422, Reason: "This timeout action does not exist.", Field: "timeoutAction"}
+	}
+	switch sub.Visibility {
+	case VisibilityPublic, VisibilityPrivate:
+		if sub.Password != "" {
+			return &tx.Error{Code: 422, Reason: "Only password-protected combats can have a password.", Field: "password"}
+		}
+	case VisibilityPassword:
+		if sub.Password == "" {
+			return &tx.Error{Code: 422, Reason: "Password-protected combats require a password.", Field: "password"}
+		}
+	default:
+		return &tx.Error{Code: 422, Reason: "This visibility does not exist.", Field: "visibility"}
+	}
+	return nil
+}
+
 // Run is the main loop for any Hub object.
 func (this *Hub) Run() {
 	log.Debug("Running new hub.")
+	// Periodically try to group queued players.
+	ticker := time.NewTicker(matchmakingInterval)
+	defer ticker.Stop()
 	// Loop.
 	for {
+		// Once shutting down, stop as soon as the last combat is over.
+		if this.shutdown != nil && len(this.combats) == 0 {
+			if this.drainTimer != nil {
+				this.drainTimer.Stop()
+			}
+			log.Info("All combats are over, closing connections.")
+			this.storeWrites.Wait()
+			this.closeConnections()
+			close(this.shutdown.Done)
+			return
+		}
+		var drainDeadline <-chan time.Time
+		if this.drainTimer != nil {
+			drainDeadline = this.drainTimer.C
+		}
 		// Wait for any event to occur.
 		select {
+		// Time to match queued players.
+		case <-ticker.C:
+			if this.shutdown == nil {
+				this.matchmake()
+			}
+
+		// Combats took too long to finish.
+		case <-drainDeadline:
+			this.drainTimer = nil
+			log.Warning("Shutdown deadline reached, aborting %d combats.", len(this.combats))
+			for _, combat := range this.combats {
+				combat.Notify(cbt.Wrap(cbt.Abort{Reason: "The server is shutting down."}))
+			}
+
 		case cmd := <-this.commandQueue:
 			player := cmd.Player
+			if this.shutdown != nil && refusedDuringShutdown(cmd.Command) {
+				player.Notify(tx.Reply(cmd.ID, tx.Error{
+					Code:   503,
+					Reason: "The server is shutting down.",
+				}))
+				continue
+			}
 
 			switch sub := cmd.Command.(type) {
 			// Register a new player.
 			case rx.Register:
 				log.Debug("Player %s registering as %s.", player.UUID(), sub.Username)
-				player.SetUsername(sub.Username)
-				player.Notify(tx.Wrap(tx.Registered{sub.Username}))
+				if sub.Password != "" {
+					// Hashing passwords is slow on purpose, so it happens away from the hub loop.
+					this.storeWrites.Add(1)
+					go func(id string, sub rx.Register) {
+						defer this.storeWrites.Done()
+						acc, err := this.store.Create(sub.Username, sub.Password)
+						this.commandQueue <- &rx.Base{Player: player, ID: id, Command: rx.AccountCreated{Account: acc, Err: err, Version: sub.Version}}
+					}(cmd.ID, sub)
+					continue
+				}
+				// Guests cannot impersonate account owners.
+				if _, err := this.store.Get(sub.Username); err != account.ErrNotFound {
+					player.Notify(tx.Reply(cmd.ID, accountError(account.ErrUsernameTaken)))
+					continue
+				}
+				this.admit(player, sub.Username)
+				player.Notify(tx.Reply(cmd.ID, tx.Registered{Username: sub.Username, Version: sub.Version, Token: player.Token()}))
+
+			// The account a player registered is ready.
+			case rx.AccountCreated:
+				if sub.Err != nil {
+					player.Notify(tx.Reply(cmd.ID, accountError(sub.Err)))
+					continue
+				}
+				// The player might have left while his password was being hashed.
+				if !player.IsConnected() {
+					continue
+				}
+				acc := sub.Account
+				this.admit(player, acc.Username)
+				this.logins[strings.ToLower(acc.Username)] = player
+				player.SetAccount(acc.Username)
+				player.Notify(tx.Reply(cmd.ID, tx.Registered{Username: acc.Username, Version: sub.Version, Token: player.Token()}))
+
+			// A player logs into his account.
+			case rx.Login:
+				go func(id string, sub rx.Login) {
+					acc, err := this.store.Authenticate(sub.Username, sub.Password)
+					if err != nil {
+						log.Warning("Player %s failed to log in as %s.", player.UUID(), sub.Username)
+					}
+					this.commandQueue <- &rx.Base{Player: player, ID: id, Command: rx.Authenticated{Account: acc, Err: err, Version: sub.Version}}
+				}(cmd.ID, sub)
+
+			// A player's credentials were checked.
+			case rx.Authenticated:
+				if sub.Err != nil {
+					player.Notify(tx.Reply(cmd.ID, accountError(sub.Err)))
+					continue
+				}
+				if !player.IsConnected() {
+					continue
+				}
+				acc := sub.Account
+				if other, ok := this.logins[strings.ToLower(acc.Username)]; ok && other != player {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "This account is already in use.",
+					}))
+					continue
+				}
+				log.Debug("Player %s logged in as %s.", player.UUID(), acc.Username)
+				this.admit(player, acc.Username)
+				player.SetLevel(acc.Level)
+				this.logins[strings.ToLower(acc.Username)] = player
+				player.SetAccount(acc.Username)
+				player.Notify(tx.Reply(cmd.ID, tx.LoggedIn{
+					Username: acc.Username,
+					Version:  sub.Version,
+					Token:    player.Token(),
+					Level:    acc.Level,
+					XP:       acc.XP,
+				}))
 
 			// Unregisters a player.
 			case rx.Unregister:
 				log.Debug("Player disconnected %s.", player.UUID())
-				// Player was in a combat, remove him.
-				player.LeaveCombat()
+				player.Disconnect()
 				player.Connection().Close()
+				if !player.IsInCombat() {
+					this.forget(player)
+					continue
+				}
+				// Player was in a combat, give him some time to come back before removing him.
+				deadline := time.Now().Add(*config.SessionGracePeriod)
+				this.expirations[player.UUID()] = deadline
+				time.AfterFunc(*config.SessionGracePeriod, func() {
+					this.commandQueue <- rx.Wrap(player, rx.SessionExpire{Deadline: deadline})
+				})
+
+			// A player wants to take over a session he lost.
+			case rx.Resume:
+				// The fresh connection would otherwise leave a seat behind in its own combat.
+				if player.IsInCombat() || this.matchmaker.IsQueued(player) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You have to leave your combat before resuming a session.",
+					}))
+					continue
+				}
+				previous, ok := this.sessions[sub.Token]
+				if !ok || !previous.Resume(player) {
+					log.Warning("Player %s failed to resume a session.", player.UUID())
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "There is no session to resume with this token.",
+					}))
+					continue
+				}
+				log.Debug("Player %s resumed his session.", previous.UUID())
+				this.forget(player)
+				delete(this.expirations, previous.UUID())
+				previous.Notify(tx.Reply(cmd.ID, tx.Resumed{Player: previous.AsSendable()}))
+				if combat := previous.Combat(); combat != nil {
+					combat.Notify(cbt.Reply(cmd.ID, cbt.Resume{Player: previous}))
+				}
+
+			// A disconnected player didn't come back in time.
+			case rx.SessionExpire:
+				if deadline, ok := this.expirations[player.UUID()]; !ok || !deadline.Equal(sub.Deadline) {
+					continue
+				}
+				log.Debug("Session of player %s expired.", player.UUID())
+				delete(this.expirations, player.UUID())
+				player.LeaveCombat()
+				this.forget(player)
+
+			// Player says something.
+			case rx.ChatSend:
+				if _, ok := this.players[player.UUID()]; !ok {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   403,
+						Reason: "You have to register before chatting.",
+					}))
+					continue
+				}
+				now := time.Now()
+				if !this.chatLimiter.Allow(player.UUID(), now) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   429,
+						Reason: "You are sending messages too fast.",
+					}))
+					continue
+				}
+				text, err := chat.Clean(sub.Text, config.ChatFilter)
+				if err != nil {
+					player.Notify(tx.Reply(cmd.ID, chatError(err)))
+					continue
+				}
+				msg := tx.ChatMessage{
+					Channel:  sub.Channel,
+					From:     player.Username(),
+					FromUUID: player.UUID(),
+					Text:     text,
+					Time:     now.UnixNano() / int64(time.Millisecond),
+				}
+				switch sub.Channel {
+				case chat.ChannelGlobal:
+					for _, other := range this.players {
+						if other != player && other.IsConnected() && !other.TryNotify(tx.Wrap(msg)) {
+							log.Warning("Player %s is not keeping up, a chat message was dropped.", other.UUID())
+						}
+					}
+					player.Notify(tx.Reply(cmd.ID, msg))
+				case chat.ChannelCombat:
+					// Combats broadcast their messages themselves, to players and spectators alike.
+					combat := player.Combat()
+					if combat == nil {
+						player.Notify(tx.Reply(cmd.ID, tx.Error{
+							Code:   409,
+							Reason: "You are not in a combat.",
+							Field:  "channel",
+						}))
+						continue
+					}
+					combat.Notify(cbt.Reply(cmd.ID, cbt.Chat{Player: player, Message: msg}))
+				case chat.ChannelDirect:
+					recipient := this.online(sub.To)
+					if recipient == nil {
+						player.Notify(tx.Reply(cmd.ID, tx.Error{
+							Code:   404,
+							Reason: "This player is not online.",
+							Field:  "to",
+						}))
+						continue
+					}
+					msg.To = recipient.Username()
+					if recipient != player && !recipient.TryNotify(tx.Wrap(msg)) {
+						log.Warning("Player %s is not keeping up, a chat message was dropped.", recipient.UUID())
+					}
+					player.Notify(tx.Reply(cmd.ID, msg))
+				}
+
+			// Player wants to be matched with other players.
+			case rx.MatchmakingJoin:
+				if player.IsInCombat() || this.matchmaker.IsQueued(player) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You are already in a combat or waiting for one.",
+					}))
+					continue
+				}
+				// Difficulties are the names of generation presets.
+				difficulty := sub.Difficulty
+				if difficulty == "" {
+					difficulty = gen.DefaultPreset
+				}
+				if _, ok := gen.Preset(difficulty); !ok {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "This difficulty does not exist.",
+						Field:  "difficulty",
+					}))
+					continue
+				}
+				log.Debug("Player %s is looking for a %d players %s combat.", player.UUID(), sub.Players, difficulty)
+				now := time.Now()
+				t := this.matchmaker.Enqueue(player, cmd.ID, sub.Players, difficulty, now)
+				player.Notify(this.matchmakingStatus(t, now))
+
+			// Player doesn't want to be matched anymore.
+			case rx.MatchmakingLeave:
+				if !this.matchmaker.Dequeue(player) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "You are not waiting for a combat.",
+					}))
+					continue
+				}
+				player.Notify(tx.Reply(cmd.ID, tx.MatchmakingLeft{}))
 
 			// Player wants a list of existing combats.
 			case rx.CombatList:
 				time.Sleep(time.Second * 1)
-				// TODO: Remove this from there!!!
-				for len(this.combats) < 2 {
-					log.Warning("This server only has %d combats, creating one more.", len(this.combats))
-					combat := NewCombat(1, 1)
-					this.combats[combat.UUID()] = combat
-					go func(combat *Combat, ch chan<- *rx.Base) {
-						combat.Run()
-						ch <- rx.Wrap(nil, rx.CombatEnd{UUID: combat.uuid})
-					}(combat, this.commandQueue)
-				}
 				// Retrieve a list of combats.
 				combats := make([]util.MapHelper, 0)
-				comms := make(chan util.MapHelper)
+				// Combats which are over but not yet forgotten never answer, their summaries are not waited for forever.
+				comms := make(chan util.MapHelper, len(this.combats))
 				for _, combat := range this.combats {
 					go combat.Summarize(comms)
-					summary := <-comms
-					if !summary["started"].(bool) {
+				}
+				deadline := time.After(combatSummaryTimeout)
+			summaries:
+				for range this.combats {
+					var summary util.MapHelper
+					select {
+					case summary = <-comms:
+					case <-deadline:
+						log.Warning("Some combats did not describe themselves in time.")
+						break summaries
+					}
+					// Private combats can only be found by players knowing their UUID.
+					if summary["visibility"] == VisibilityPrivate {
+						continue
+					}
+					if sub.Running || !summary["started"].(bool) {
 						combats = append(combats, summary)
 					}
 				}
-				player.Notify(tx.Wrap(tx.CombatList{Combats: combats}))
+				player.Notify(tx.Reply(cmd.ID, tx.CombatList{Combats: combats}))
 
 			// Player wants to create a combat.
 			case rx.CombatCreate:
-				combat := NewCombat(sub.MinPlayers, sub.MaxPlayers)
-				this.combats[combat.UUID()] = combat
-				go func(combat *Combat, ch chan<- *rx.Base) {
-					combat.Run()
-					ch <- rx.Wrap(nil, rx.CombatEnd{UUID: combat.uuid})
-				}(combat, this.commandQueue)
-				this.commandQueue <- rx.Wrap(player, rx.CombatJoin{UUID: combat.UUID()})
+				if player.IsInCombat() || this.matchmaker.IsQueued(player) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You are already in a combat or waiting for one.",
+					}))
+					continue
+				}
+				if sub.Visibility == "" {
+					sub.Visibility = VisibilityPublic
+				}
+				if err := combatOptionsError(sub); err != nil {
+					player.Notify(tx.Reply(cmd.ID, *err))
+					continue
+				}
+				profile := sub.Profile
+				if profile == nil {
+					difficulty := sub.Difficulty
+					if difficulty == "" {
+						difficulty = gen.DefaultPreset
+					}
+					var ok bool
+					if profile, ok = gen.Preset(difficulty); !ok {
+						player.Notify(tx.Reply(cmd.ID, tx.Error{
+							Code:   422,
+							Reason: "This difficulty does not exist.",
+							Field:  "difficulty",
+						}))
+						continue
+					}
+				}
+				if err := profile.Validate(config.Polycubes); err != nil {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "Invalid generation profile: " + err.Error() + ".",
+						Field:  "profile",
+					}))
+					continue
+				}
+				combat := NewCombat(sub.MinPlayers, sub.MaxPlayers, sub.TurnDuration, sub.TimeoutAction, profile)
+				combat.visibility, combat.password, combat.owner = sub.Visibility, sub.Password, player.UUID()
+				// The creator hosts the lobby.
+				combat.host, combat.countdown = player.UUID(), sub.Countdown
+				log.Debug("Player %s created the %s combat %s.", player.UUID(), combat.visibility, combat.UUID())
+				this.startCombat(combat)
+				// The creator joins right away, and gets the combat summary in return.
+				player.JoinCombat(combat, cmd.ID)
+				if combat.visibility == VisibilityPrivate {
+					inv := this.invites.Issue(combat, player, nil)
+					player.Notify(tx.Wrap(tx.CombatInviteCode{UUID: combat.UUID(), Code: inv.code}))
+				}
 
 			// Player wants to join a combat.
 			case rx.CombatJoin:
+				this.join(player, cmd.ID, sub)
+
+			// Player wants another player to join his combat.
+			case rx.CombatInvite:
+				combat := this.ownedCombat(player)
+				if combat == nil {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   403,
+						Reason: "Only the creator of a combat can invite players.",
+					}))
+					continue
+				}
+				invitee := this.online(sub.Username)
+				if invitee == nil {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "This player is not online.",
+						Field:  "username",
+					}))
+					continue
+				} else if invitee == player {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   422,
+						Reason: "You cannot invite yourself.",
+						Field:  "username",
+					}))
+					continue
+				} else if invitee.IsInCombat() {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "This player is already in a combat.",
+						Field:  "username",
+					}))
+					continue
+				}
+				inv := this.invites.Issue(combat, player, invitee)
+				invitee.Notify(tx.Wrap(tx.CombatInvitation{UUID: combat.UUID(), Code: inv.code, From: player.Username()}))
+				player.Notify(tx.Reply(cmd.ID, tx.CombatInviteSent{Code: inv.code, Username: invitee.Username()}))
+
+			// Player answers an invitation.
+			case rx.CombatInviteAnswer:
+				inv := this.invites.Find(sub.Code)
+				if inv == nil || inv.to != player {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "This invitation is not valid anymore.",
+						Field:  "code",
+					}))
+					continue
+				}
+				if sub.Accept {
+					this.join(player, cmd.ID, rx.CombatJoin{Code: sub.Code})
+					continue
+				}
+				this.invites.Revoke(inv.code)
+				declined := tx.CombatInviteDeclined{Code: inv.code, Username: player.Username()}
+				inv.from.Notify(tx.Wrap(declined))
+				player.Notify(tx.Reply(cmd.ID, declined))
+
+			// The creator of a combat doesn't want an invite code to be usable anymore.
+			case rx.CombatInviteRevoke:
+				inv := this.invites.Find(sub.Code)
+				if inv == nil || inv.combat.Owner() != player.UUID() {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "This invite code is not valid.",
+						Field:  "code",
+					}))
+					continue
+				}
+				this.invites.Revoke(inv.code)
+				if inv.to != nil {
+					inv.to.Notify(tx.Wrap(tx.CombatInviteRevoked{Code: inv.code}))
+				}
+				player.Notify(tx.Reply(cmd.ID, tx.CombatInviteRevoked{Code: inv.code}))
+
+			// The creator of a private combat wants a new code to share.
+			case rx.CombatInviteCode:
+				combat := this.ownedCombat(player)
+				if combat == nil || combat.Visibility() != VisibilityPrivate {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   403,
+						Reason: "Only the creator of a private combat can get invite codes.",
+					}))
+					continue
+				}
+				inv := this.invites.Issue(combat, player, nil)
+				player.Notify(tx.Reply(cmd.ID, tx.CombatInviteCode{UUID: combat.UUID(), Code: inv.code}))
+
+			// Player wants to watch a combat.
+			case rx.CombatSpectate:
 				combat := this.combats[sub.UUID]
 				if combat == nil {
-					log.Warning("The combat %s requested by player %s doesn't exist.", sub.UUID, player.UUID())
-					player.Notify(tx.Wrap(tx.Error{
+					log.Warning("The combat %s player %s wants to watch doesn't exist.", sub.UUID, player.UUID())
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
 						Code:   404,
 						Reason: "Combat was not found.",
 					}))
 					continue
 				}
-				player.JoinCombat(combat)
+				if player.IsInCombat() || this.matchmaker.IsQueued(player) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You cannot watch a combat while playing or waiting for one.",
+					}))
+					continue
+				}
+				if combat.Visibility() == VisibilityPrivate {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   403,
+						Reason: "Private combats cannot be watched.",
+					}))
+					continue
+				}
+				player.SpectateCombat(combat, cmd.ID)
+
+			// Player wants to watch a recorded combat.
+			case rx.CombatReplay:
+				if player.IsInCombat() || this.matchmaker.IsQueued(player) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You cannot watch a replay while playing or waiting for a combat.",
+					}))
+					continue
+				}
+				if *config.ReplayPath == "" {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   404,
+						Reason: "Replays are disabled on this server.",
+					}))
+					continue
+				}
+				// Replays can be long, so they are read away from the hub loop.
+				go func(id string, sub rx.CombatReplay) {
+					entries, err := replay.Load(*config.ReplayPath, sub.ID)
+					this.commandQueue <- &rx.Base{Player: player, ID: id, Command: rx.ReplayLoaded{ID: sub.ID, Entries: entries, Err: err}}
+				}(cmd.ID, sub)
+
+			// A replay a player asked for was read.
+			case rx.ReplayLoaded:
+				if sub.Err != nil {
+					log.Warning("Player %s failed to load replay %s: %s", player.UUID(), sub.ID, sub.Err)
+					player.Notify(tx.Reply(cmd.ID, replayError(sub.Err)))
+					continue
+				}
+				// The player might have left or found something else to do in the meantime.
+				if !player.IsConnected() {
+					continue
+				} else if player.IsInCombat() || this.matchmaker.IsQueued(player) {
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   409,
+						Reason: "You cannot watch a replay while playing or waiting for a combat.",
+					}))
+					continue
+				}
+				entries := sub.Entries
+				if !this.mayWatch(player, entries[0]) {
+					log.Warning("Player %s is not allowed to watch replay %s.", player.UUID(), sub.ID)
+					player.Notify(tx.Reply(cmd.ID, tx.Error{
+						Code:   403,
+						Reason: "This replay is private.",
+					}))
+					continue
+				}
+				combat := NewReplayCombat(entries)
+				player.SpectateCombat(combat, cmd.ID)
+				go combat.Replay(entries, player)
 
 			// A combat has ended.
 			case rx.CombatEnd:
 				if _, ok := this.combats[sub.UUID]; ok {
 					delete(this.combats, sub.UUID)
 				}
+				for _, inv := range this.invites.RevokeCombat(sub.UUID) {
+					inv.to.Notify(tx.Wrap(tx.CombatInviteRevoked{Code: inv.code}))
+				}
+				// Players logged into an account earn experience. Accounts are saved away from the hub loop.
+				for _, result := range sub.Results {
+					player, ok := this.players[result.PlayerUUID]
+					if !ok || !this.isLoggedIn(player) {
+						continue
+					}
+					xp := experienceFor(result, len(sub.Results))
+					this.storeWrites.Add(1)
+					go func(player i.Player, username string, xp int) {
+						defer this.storeWrites.Done()
+						acc, err := this.store.Credit(username, xp)
+						this.commandQueue <- &rx.Base{Player: player, Command: rx.Credited{Account: acc, Gained: xp, Err: err}}
+					}(player, player.Account(), xp)
+				}
+
+			// A player's account was given experience.
+			case rx.Credited:
+				if sub.Err != nil {
+					log.Warning("Failed to credit player %s with %d XP: %s", player.UUID(), sub.Gained, sub.Err)
+					continue
+				}
+				// The player might have logged out in the meantime.
+				if !strings.EqualFold(player.Account(), sub.Account.Username) {
+					continue
+				}
+				player.SetLevel(sub.Account.Level)
+				player.Notify(tx.Wrap(tx.Progress{Level: sub.Account.Level, XP: sub.Account.XP, Gained: sub.Gained}))
+
+			// The server is going away.
+			case rx.Shutdown:
+				if this.shutdown != nil {
+					continue
+				}
+				log.Info("Shutting down, giving %d combats %s to finish.", len(this.combats), sub.Drain)
+				this.shutdown = &sub
+				this.drainTimer = time.NewTimer(sub.Drain)
+				notice := tx.Wrap(tx.ServerShutdown{
+					Reason:   "The server is shutting down.",
+					Deadline: int(sub.Drain / time.Millisecond),
+				})
+				for _, other := range this.players {
+					other.TryNotify(notice)
+				}
+				// Nobody waits for a combat which will never start.
+				this.matchmaker.Clear()
+				for _, combat := range this.combats {
+					combat.Notify(cbt.Wrap(cbt.Drain{Reason: "The server is shutting down."}))
+				}
 
 			// How is that even possible?
 			default:
 				log.Warning("Player %s sent an unhandled command type: %s.", player.UUID(), reflect.TypeOf(sub))
-				player.Notify(tx.Wrap(tx.Error{
+				player.Notify(tx.Reply(cmd.ID, tx.Error{
 					Code:   422,
 					Reason: "The command you sent could not be understood by the server.",
 				}))
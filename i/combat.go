@@ -10,4 +10,7 @@ type Combat interface {
 	AsSendable() util.MapHelper    // Serialization.
 	Run()                          // Start running the combat events loop.
 	Summarize(chan util.MapHelper) // Gets the summary of a combat.
+	Visibility() string            // Who can see and join the combat.
+	Admits(password string) bool   // Whether a password lets players join the combat.
+	Owner() string                 // The UUID of the player who created the combat, if any.
 }
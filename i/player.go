@@ -3,18 +3,28 @@ package i
 import (
 	"github.com/hickscorp/communitrix-server/cmd/tx"
 	"github.com/hickscorp/communitrix-server/util"
-	"net"
 )
 
 type Player interface {
-	UUID() string                // UUID.
-	Username() string            // Username.
-	SetUsername(username string) // Setter on Username.
-	Level() int                  // Level.
-	Connection() net.Conn        // Connection.
-	Notify(*tx.Base)             // Send somthing to a player.
-	Combat() Combat              // Combat if any.
-	AsSendable() util.MapHelper  // Serialization.
-	JoinCombat(combat Combat)    // Join a combat.
-	LeaveCombat()                // Leave a combat.
+	UUID() string                                   // UUID.
+	Username() string                               // Username.
+	SetUsername(username string)                    // Setter on Username.
+	Level() int                                     // Level.
+	SetLevel(level int)                             // Setter on Level.
+	Account() string                                // The account logged into, if any.
+	SetAccount(account string)                      // Setter on Account.
+	Connection() Transport                          // Connection.
+	Notify(*tx.Base)                                // Send somthing to a player.
+	TryNotify(*tx.Base) bool                        // Send something to a player, unless its queue is full.
+	Combat() Combat                                 // Combat if any.
+	IsInCombat() bool                               // Whether there is a combat.
+	AsSendable() util.MapHelper                     // Serialization.
+	JoinCombat(combat Combat, requestID string)     // Join a combat.
+	LeaveCombat()                                   // Leave a combat.
+	SpectateCombat(combat Combat, requestID string) // Watch a combat.
+	ForgetCombat(combat Combat)                     // Drop the reference to a combat which is over.
+	Token() string                                  // Session token.
+	IsConnected() bool                              // Whether a connection is bound.
+	Disconnect()                                    // Mark the connection as lost.
+	Resume(from Player) bool                        // Take over the connection of another player.
 }
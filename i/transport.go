@@ -0,0 +1,9 @@
+package i
+
+import "github.com/hickscorp/communitrix-server/cmd/tx"
+
+type Transport interface {
+	Read() ([]byte, error) // Read the next inbound packet.
+	Write(*tx.Base) error  // Send an outbound command.
+	Close() error          // Close the underlying connection.
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/base32"
+	"github.com/hickscorp/communitrix-server/i"
+)
+
+// inviteCodeLength is the number of random bytes invite codes are made of.
+const inviteCodeLength = 10
+
+// Invites keeps track of the codes allowing players into private combats.
+type Invites struct {
+	codes map[string]*invite // Valid invites, by code.
+}
+
+// invite lets players join a combat.
+type invite struct {
+	code   string
+	combat i.Combat // The combat the code gives access to.
+	from   i.Player // The player who issued the invite.
+	to     i.Player // The only player allowed to use the code, nil meaning anyone knowing it.
+}
+
+func NewInvites() *Invites {
+	return &Invites{codes: make(map[string]*invite)}
+}
+
+// Issue creates a new code for a combat.
+func (this *Invites) Issue(combat i.Combat, from, to i.Player) *invite {
+	inv := &invite{code: newInviteCode(), combat: combat, from: from, to: to}
+	this.codes[inv.code] = inv
+	return inv
+}
+
+// Find gives the invite a code stands for, if it is still valid.
+func (this *Invites) Find(code string) *invite {
+	return this.codes[code]
+}
+
+// Revoke invalidates a code.
+func (this *Invites) Revoke(code string) {
+	delete(this.codes, code)
+}
+
+// RevokeCombat invalidates all the codes of a combat, returning the invites which were addressed to someone.
+func (this *Invites) RevokeCombat(uuid string) []*invite {
+	ret := make([]*invite, 0)
+	for code, inv := range this.codes {
+		if inv.combat.UUID() != uuid {
+			continue
+		}
+		delete(this.codes, code)
+		if inv.to != nil {
+			ret = append(ret, inv)
+		}
+	}
+	return ret
+}
+
+// Forget invalidates the invites addressed to a player.
+func (this *Invites) Forget(player i.Player) {
+	for code, inv := range this.codes {
+		if inv.to == player {
+			delete(this.codes, code)
+		}
+	}
+}
+
+// newInviteCode generates an unguessable code, short enough to be shared by hand.
+func newInviteCode() string {
+	buf := make([]byte, inviteCodeLength)
+	if _, err := crand.Read(buf); err != nil {
+		log.Error("Unable to generate an invite code: %s", err)
+	}
+	return base32.StdEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/hickscorp/communitrix-server/cmd/cbt"
+	"github.com/hickscorp/communitrix-server/cmd/tx"
+	"github.com/hickscorp/communitrix-server/i"
+	"sort"
+	"time"
+)
+
+// canStart checks whether the lobby has enough players, all of them being ready.
+func (this *Combat) canStart() bool {
+	if this.launching || len(this.players) < this.minPlayers {
+		return false
+	}
+	for uuid := range this.players {
+		if !this.ready[uuid] {
+			return false
+		}
+	}
+	return true
+}
+
+// readyPlayers lists the UUIDs of the players ready to start.
+func (this *Combat) readyPlayers() []string {
+	ret := make([]string, 0, len(this.ready))
+	for uuid, ready := range this.ready {
+		if ready {
+			ret = append(ret, uuid)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// launch leaves the lobby, and prepares the combat. The lobby closes right away, as preparing is queued.
+func (this *Combat) launch() {
+	this.launching = true
+	this.stopCountdown()
+	this.commandQueue <- cbt.Wrap(cbt.Prepare{})
+}
+
+// updateCountdown arms the lobby countdown as soon as the combat can start, and cancels it whenever it cannot anymore.
+func (this *Combat) updateCountdown() {
+	if this.host == "" || this.countdown <= 0 {
+		return
+	}
+	if !this.canStart() {
+		if this.countdownTimer != nil {
+			this.stopCountdown()
+			this.notifyPlayers(func(i.Player) *tx.Base { return tx.Wrap(tx.CombatCountdown{Cancelled: true}) }, false)
+		}
+		return
+	}
+	if this.countdownTimer == nil {
+		this.countdownTimer = time.NewTimer(this.countdown)
+		this.countdownEndsAt = time.Now().Add(this.countdown)
+		this.notifyCountdown()
+	}
+}
+
+// stopCountdown disarms the lobby countdown, if any.
+func (this *Combat) stopCountdown() {
+	if this.countdownTimer != nil {
+		this.countdownTimer.Stop()
+		this.countdownTimer = nil
+	}
+}
+
+// notifyCountdown tells everyone how much time is left before the combat starts.
+func (this *Combat) notifyCountdown() {
+	remaining := this.countdownEndsAt.Sub(time.Now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	countdownNotif := func(i.Player) *tx.Base {
+		return tx.Wrap(tx.CombatCountdown{
+			Duration:  int(this.countdown / time.Millisecond),
+			Remaining: int(remaining / time.Millisecond),
+		})
+	}
+	this.notifyPlayers(countdownNotif, false)
+}
+
+// passHost hands the lobby over to another player once the host is gone.
+func (this *Combat) passHost() {
+	uuids := make([]string, 0, len(this.players))
+	for uuid := range this.players {
+		uuids = append(uuids, uuid)
+	}
+	if len(uuids) == 0 {
+		return
+	}
+	sort.Strings(uuids)
+	this.host = uuids[0]
+	log.Debug("Player %s is now the host of combat %s.", this.host, this.uuid)
+	this.notifyPlayers(func(i.Player) *tx.Base { return tx.Wrap(tx.CombatHost{PlayerUUID: this.host}) }, false)
+}
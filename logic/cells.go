@@ -12,19 +12,10 @@ func (this Cells) Clone() Cells {
 	return ret
 }
 
-func (this Cells) Shuffle() Cells {
+func (this Cells) Shuffle(rng *rand.Rand) Cells {
   for i := len(this) - 1; i > 0; i-- {
-    j := rand.Intn(i)
+    j := rng.Intn(i)
     this[i], this[j] = this[j], this[i]
   }
   return this
 }
-
-func (this Cells) CollidesWith(c *Cell) bool {
-  for _, cell := range this {
-    if cell.Vector.CollidesWith(c.Vector) {
-      return true
-    }
-  }
-  return false
-}
@@ -0,0 +1,153 @@
+package logic
+
+import "math"
+
+// OrientationTolerance is how far from a right angle rotation a quaternion can be while still being accepted.
+const OrientationTolerance = 0.01
+
+// Orientation is one of the 24 proper rotations of the cube, as an integer matrix.
+type Orientation [3][3]int
+
+// Identity is the orientation leaving everything untouched.
+var Identity = Orientation{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// The 24 orientations are the signed permutation matrices having a determinant of one. Identity comes first.
+var orientations = func() []Orientation {
+	perms := [][3]int{{0, 1, 2}, {1, 2, 0}, {2, 0, 1}, {0, 2, 1}, {2, 1, 0}, {1, 0, 2}}
+	ret := make([]Orientation, 0, 24)
+	for p, perm := range perms {
+		parity := 1
+		if p >= 3 {
+			parity = -1
+		}
+		for signs := 0; signs < 8; signs++ {
+			s := [3]int{1 - 2*(signs&1), 1 - 2*(signs>>1&1), 1 - 2*(signs>>2&1)}
+			// Leave reflections out.
+			if parity*s[0]*s[1]*s[2] != 1 {
+				continue
+			}
+			o := Orientation{}
+			for row := 0; row < 3; row++ {
+				o[row][perm[row]] = s[row]
+			}
+			ret = append(ret, o)
+		}
+	}
+	return ret
+}()
+
+// Orientations lists the 24 proper rotations of the cube.
+func Orientations() []Orientation {
+	ret := make([]Orientation, len(orientations))
+	copy(ret, orientations)
+	return ret
+}
+
+// OrientationFromQuaternion finds the orientation a quaternion stands for. It fails when the quaternion isn't a right angle rotation.
+func OrientationFromQuaternion(q *Quaternion) (Orientation, bool) {
+	norm := math.Sqrt(q.X*q.X + q.Y*q.Y + q.Z*q.Z + q.W*q.W)
+	if norm < OrientationTolerance {
+		return Identity, false
+	}
+	x, y, z, w := q.X/norm, q.Y/norm, q.Z/norm, q.W/norm
+	m := [3][3]float64{
+		{1 - 2*(y*y+z*z), 2 * (x*y - w*z), 2 * (x*z + w*y)},
+		{2 * (x*y + w*z), 1 - 2*(x*x+z*z), 2 * (y*z - w*x)},
+		{2 * (x*z - w*y), 2 * (y*z + w*x), 1 - 2*(x*x+y*y)},
+	}
+	ret := Orientation{}
+	for row := range m {
+		for col := range m[row] {
+			rounded := math.Floor(m[row][col] + 0.5)
+			if math.Abs(m[row][col]-rounded) > OrientationTolerance {
+				return Identity, false
+			}
+			ret[row][col] = int(rounded)
+		}
+	}
+	// Rounding a nearly right angle rotation always gives one of ours, but better safe than sorry.
+	for _, o := range orientations {
+		if o == ret {
+			return ret, true
+		}
+	}
+	return Identity, false
+}
+
+// Quaternion converts this orientation to a unit quaternion.
+func (this Orientation) Quaternion() *Quaternion {
+	m := func(row, col int) float64 { return float64(this[row][col]) }
+	trace := m(0, 0) + m(1, 1) + m(2, 2)
+	switch {
+	case trace > 0:
+		s := 2 * math.Sqrt(1+trace)
+		return &Quaternion{X: (m(2, 1) - m(1, 2)) / s, Y: (m(0, 2) - m(2, 0)) / s, Z: (m(1, 0) - m(0, 1)) / s, W: s / 4}
+	case m(0, 0) > m(1, 1) && m(0, 0) > m(2, 2):
+		s := 2 * math.Sqrt(1+m(0, 0)-m(1, 1)-m(2, 2))
+		return &Quaternion{X: s / 4, Y: (m(0, 1) + m(1, 0)) / s, Z: (m(0, 2) + m(2, 0)) / s, W: (m(2, 1) - m(1, 2)) / s}
+	case m(1, 1) > m(2, 2):
+		s := 2 * math.Sqrt(1+m(1, 1)-m(0, 0)-m(2, 2))
+		return &Quaternion{X: (m(0, 1) + m(1, 0)) / s, Y: s / 4, Z: (m(1, 2) + m(2, 1)) / s, W: (m(0, 2) - m(2, 0)) / s}
+	default:
+		s := 2 * math.Sqrt(1+m(2, 2)-m(0, 0)-m(1, 1))
+		return &Quaternion{X: (m(0, 2) + m(2, 0)) / s, Y: (m(1, 2) + m(2, 1)) / s, Z: s / 4, W: (m(1, 0) - m(0, 1)) / s}
+	}
+}
+
+// Compose gives the orientation applying the other orientation first, then this one.
+func (this Orientation) Compose(other Orientation) Orientation {
+	ret := Orientation{}
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			for k := 0; k < 3; k++ {
+				ret[row][col] += this[row][k] * other[k][col]
+			}
+		}
+	}
+	return ret
+}
+
+// Inverse gives the orientation undoing this one. Rotation matrices are orthogonal, so it's the transpose.
+func (this Orientation) Inverse() Orientation {
+	ret := Orientation{}
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			ret[row][col] = this[col][row]
+		}
+	}
+	return ret
+}
+
+// Apply rotates a vector value.
+func (this Orientation) Apply(v Vector) Vector {
+	return Vector{
+		this[0][0]*v.X + this[0][1]*v.Y + this[0][2]*v.Z,
+		this[1][0]*v.X + this[1][1]*v.Y + this[1][2]*v.Z,
+		this[2][0]*v.X + this[2][1]*v.Y + this[2][2]*v.Z,
+	}
+}
+
+// Orient applies an exact rotation to the current object. The current object is then returned for chaining.
+func (this *Vector) Orient(o Orientation) *Vector {
+	*this = o.Apply(*this)
+	return this
+}
+
+// Orient applies an exact rotation to the current object, leaving its value untouched. The current object is then returned for chaining.
+func (this *Cell) Orient(o Orientation) *Cell {
+	this.Vector.Orient(o)
+	return this
+}
+
+// Orient applies an exact rotation to the current object, and updates its bounds. The current object is then returned for chaining.
+func (this *Piece) Orient(o Orientation) *Piece {
+	this.voxels = nil
+	for _, cell := range this.Content {
+		cell.Orient(o)
+	}
+	if !this.IsEmpty() {
+		this.Min, this.Max = cellsBounds(this.Content)
+		this.Size = this.Max.Clone().Sub(this.Min).Add(NewVectorFromValues(1, 1, 1))
+	}
+	return this
+}
@@ -0,0 +1,111 @@
+package logic
+
+import (
+	"math"
+	"testing"
+)
+
+// probes are a few vectors to move around.
+var probes = []Vector{{1, 2, 3}, {-4, 0, 7}, {0, -5, 2}, {6, -1, -9}}
+
+func TestOrientationsAreTheCubeRotations(t *testing.T) {
+	all := Orientations()
+	if len(all) != 24 {
+		t.Fatalf("got %d orientations, want 24", len(all))
+	}
+	if all[0] != Identity {
+		t.Errorf("the first orientation is %v, want the identity", all[0])
+	}
+	seen := make(map[Orientation]bool)
+	for _, o := range all {
+		if seen[o] {
+			t.Errorf("orientation %v is listed twice", o)
+		}
+		seen[o] = true
+		det := o[0][0]*(o[1][1]*o[2][2]-o[1][2]*o[2][1]) - o[0][1]*(o[1][0]*o[2][2]-o[1][2]*o[2][0]) + o[0][2]*(o[1][0]*o[2][1]-o[1][1]*o[2][0])
+		if det != 1 {
+			t.Errorf("orientation %v has a determinant of %d, want 1", o, det)
+		}
+	}
+	// Callers get their own copy.
+	all[0] = Orientation{}
+	if Orientations()[0] != Identity {
+		t.Error("orientations can be changed from the outside")
+	}
+}
+
+func TestOrientationQuaternionRoundTrip(t *testing.T) {
+	for _, o := range Orientations() {
+		q := o.Quaternion()
+		if norm := math.Sqrt(q.X*q.X + q.Y*q.Y + q.Z*q.Z + q.W*q.W); math.Abs(norm-1) > 1e-9 {
+			t.Errorf("orientation %v gives a quaternion of norm %f, want 1", o, norm)
+		}
+		// Opposite and scaled quaternions stand for the same rotation.
+		for _, other := range []*Quaternion{q, {-q.X, -q.Y, -q.Z, -q.W}, {3 * q.X, 3 * q.Y, 3 * q.Z, 3 * q.W}} {
+			if back, ok := OrientationFromQuaternion(other); !ok || back != o {
+				t.Errorf("quaternion %v gives orientation %v (%v), want %v", *other, back, ok, o)
+			}
+		}
+		// Clients send floats, which are never quite exact.
+		nudged := &Quaternion{q.X + OrientationTolerance/10, q.Y, q.Z, q.W - OrientationTolerance/10}
+		if back, ok := OrientationFromQuaternion(nudged); !ok || back != o {
+			t.Errorf("nudged quaternion %v gives orientation %v (%v), want %v", *nudged, back, ok, o)
+		}
+	}
+	half := math.Sqrt(0.5)
+	for _, q := range []*Quaternion{{0, 0, 0, 0}, {0, 0, math.Sin(math.Pi / 8), math.Cos(math.Pi / 8)}, {0.3, 0, 0, 1}, {half, 0, 0.1, half}} {
+		if o, ok := OrientationFromQuaternion(q); ok {
+			t.Errorf("quaternion %v gives orientation %v, want none", *q, o)
+		}
+	}
+}
+
+func TestOrientationComposeAndInverse(t *testing.T) {
+	all := Orientations()
+	known := make(map[Orientation]bool, len(all))
+	for _, o := range all {
+		known[o] = true
+	}
+	for _, a := range all {
+		if got := a.Compose(a.Inverse()); got != Identity {
+			t.Errorf("%v composed with its inverse gives %v", a, got)
+		}
+		if got := a.Inverse().Compose(a); got != Identity {
+			t.Errorf("the inverse of %v composed with it gives %v", a, got)
+		}
+		if got := a.Inverse().Inverse(); got != a {
+			t.Errorf("the inverse of the inverse of %v is %v", a, got)
+		}
+		for _, b := range all {
+			ab := a.Compose(b)
+			if !known[ab] {
+				t.Fatalf("%v composed with %v gives %v, which is not a cube rotation", a, b, ab)
+			}
+			if got, want := ab.Inverse(), b.Inverse().Compose(a.Inverse()); got != want {
+				t.Errorf("the inverse of %v composed with %v is %v, want %v", a, b, got, want)
+			}
+			// Composing applies the other orientation first.
+			for _, v := range probes {
+				if got, want := ab.Apply(v), a.Apply(b.Apply(v)); got != want {
+					t.Errorf("%v composed with %v moves %v to %v, want %v", a, b, v, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestOrientationMatchesRotate(t *testing.T) {
+	for _, o := range Orientations() {
+		for _, v := range probes {
+			want := v
+			want.Rotate(o.Quaternion())
+			if got := o.Apply(v); got != want {
+				t.Errorf("orientation %v moves %v to %v, rotating gives %v", o, v, got, want)
+			}
+			oriented := v
+			if oriented.Orient(o); oriented != want {
+				t.Errorf("orienting %v by %v gives %v, rotating gives %v", v, o, oriented, want)
+			}
+		}
+	}
+}
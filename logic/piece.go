@@ -13,6 +13,8 @@ type Piece struct {
 	Min     *Vector `json:"min"`
 	Max     *Vector `json:"max"`
 	Content Cells   `json:"content"`
+	voxels  Voxels  // The positions of the cells when they were indexed, built on demand and dropped when rotated.
+	moved   Vector  // How far the cells were translated since they were indexed.
 }
 
 // Instanciator.
@@ -63,6 +65,7 @@ func (this *Piece) Clone() *Piece {
 
 // Translate applies a given translation transformation to the current object. The current object is then returned for chaining.
 func (this *Piece) Translate(t *Vector) *Piece {
+	this.moved.Translate(t)
 	this.Min.Translate(t)
 	this.Max.Translate(t)
 	for _, v := range this.Content {
@@ -73,6 +76,7 @@ func (this *Piece) Translate(t *Vector) *Piece {
 
 // Rotate applies a given rotation transformation to the current object. The current object is then returned for chaining.
 func (this *Piece) Rotate(q *Quaternion) *Piece {
+	this.voxels = nil
 	this.Size.Rotate(q)
 	for _, v := range this.Content {
 		v.Rotate(q)
@@ -85,6 +89,32 @@ func (this *Piece) IsEmpty() bool {
 }
 
 func (this *Piece) AddCell(cell *Cell) *Piece {
+	if this.voxels != nil {
+		this.voxels.Add(Vector{cell.X - this.moved.X, cell.Y - this.moved.Y, cell.Z - this.moved.Z})
+	}
 	this.Content = append(this.Content, cell)
 	return this
 }
+
+// Voxels gives the set of positions occupied by the cells of this piece. It is shared and kept up to date as cells
+// are added, so it must not be modified.
+func (this *Piece) Voxels() Voxels {
+	if this.voxels == nil || this.moved != (Vector{}) {
+		this.voxels, this.moved = NewVoxelsFromCells(this.Content), Vector{}
+	}
+	return this.voxels
+}
+
+// CollidesWith checks whether two pieces have cells at the same position. Only this piece's positions are
+// indexed, and they are looked up through its translations, so units don't get indexed again after each move.
+func (this *Piece) CollidesWith(other *Piece) bool {
+	if this.voxels == nil {
+		this.voxels, this.moved = NewVoxelsFromCells(this.Content), Vector{}
+	}
+	for _, cell := range other.Content {
+		if this.voxels.Has(Vector{cell.X - this.moved.X, cell.Y - this.moved.Y, cell.Z - this.moved.Z}) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package logic
+
+// PlacementReason is the reason code explaining whether a piece placement was accepted or not.
+type PlacementReason int
+
+const (
+	PlacementValid           PlacementReason = iota // The piece can be placed.
+	PlacementUnknownPiece                           // The piece doesn't exist.
+	PlacementInvalidRotation                        // The rotation is not a multiple of 90 degrees.
+	PlacementCollision                              // The piece overlaps cells already in the unit.
+	PlacementOutOfBounds                            // The unit would not fit inside the target bounds anymore.
+	PlacementOutsideTarget                          // The unit would have cells outside of the target silhouette.
+)
+
+var placementMessages = map[PlacementReason]string{
+	PlacementValid:           "",
+	PlacementUnknownPiece:    "The piece you played does not exist. Please play again.",
+	PlacementInvalidRotation: "An invalid rotation was detected. Please play again.",
+	PlacementCollision:       "A collision was detected. Please play again.",
+	PlacementOutOfBounds:     "The piece would go out of the target bounds. Please play again.",
+	PlacementOutsideTarget:   "The piece would go outside of the target shape. Please play again.",
+}
+
+// Message gives a human readable explanation of a reason code.
+func (this PlacementReason) Message() string { return placementMessages[this] }
+
+// SilhouettePolicy defines how cells outside of the target silhouette are handled.
+type SilhouettePolicy int
+
+const (
+	SilhouetteIgnore   SilhouettePolicy = iota // Cells outside of the target are allowed.
+	SilhouettePenalize                         // Cells outside of the target are allowed, but penalized.
+	SilhouetteReject                           // Cells outside of the target are refused.
+)
+
+// SilhouettePolicyFromString converts a policy name (ignore, penalize, reject) to a policy.
+func SilhouettePolicyFromString(name string) (SilhouettePolicy, bool) {
+	switch name {
+	case "ignore":
+		return SilhouetteIgnore, true
+	case "penalize":
+		return SilhouettePenalize, true
+	case "reject":
+		return SilhouetteReject, true
+	}
+	return SilhouetteIgnore, false
+}
+
+// Placement is the result of a placement validation.
+type Placement struct {
+	Reason  PlacementReason // Why the placement was accepted or refused.
+	Penalty int             // Number of cells this placement added outside of the target silhouette.
+}
+
+// IsValid tells whether the piece can be merged into the unit.
+func (this *Placement) IsValid() bool { return this.Reason == PlacementValid }
+
+// PlacementValidator checks pieces placed on units against a target.
+type PlacementValidator struct {
+	target *Piece
+	cells  Voxels
+	policy SilhouettePolicy
+}
+
+// NewPlacementValidator is the PlacementValidator default constructor.
+func NewPlacementValidator(target *Piece, policy SilhouettePolicy) *PlacementValidator {
+	return &PlacementValidator{target: target, cells: NewVoxelsFromCells(target.Content), policy: policy}
+}
+
+// Validate checks whether an already rotated and translated piece can be merged into a unit.
+func (this *PlacementValidator) Validate(unit *Piece, piece *Piece) *Placement {
+	if unit.CollidesWith(piece) {
+		return &Placement{Reason: PlacementCollision}
+	}
+	merged := make(Cells, 0, len(unit.Content)+len(piece.Content))
+	merged = append(append(merged, unit.Content...), piece.Content...)
+	if len(merged) == 0 {
+		return &Placement{Reason: PlacementValid}
+	}
+	min, max := cellsBounds(merged)
+	size := max.Clone().Sub(min).Add(NewVectorFromValues(1, 1, 1))
+	if size.X > this.target.Size.X || size.Y > this.target.Size.Y || size.Z > this.target.Size.Z {
+		return &Placement{Reason: PlacementOutOfBounds}
+	}
+	if this.policy == SilhouetteIgnore {
+		return &Placement{Reason: PlacementValid}
+	}
+	extra := this.extraCells(merged)
+	if extra == 0 {
+		return &Placement{Reason: PlacementValid}
+	} else if this.policy == SilhouetteReject {
+		return &Placement{Reason: PlacementOutsideTarget}
+	}
+	// Only penalize the cells this placement is responsible for.
+	penalty := extra
+	if !unit.IsEmpty() {
+		penalty -= this.extraCells(unit.Content)
+	}
+	if penalty < 0 {
+		penalty = 0
+	}
+	return &Placement{Reason: PlacementValid, Penalty: penalty}
+}
+
+// extraCells counts the cells lying outside of the target silhouette, using the translation that fits it best.
+func (this *PlacementValidator) extraCells(cells Cells) int {
+	min, max := cellsBounds(cells)
+	best := len(cells)
+	t := NewVectorFromValues(0, 0, 0)
+	for t.X = this.target.Min.X - min.X; t.X <= this.target.Max.X-max.X; t.X++ {
+		for t.Y = this.target.Min.Y - min.Y; t.Y <= this.target.Max.Y-max.Y; t.Y++ {
+			for t.Z = this.target.Min.Z - min.Z; t.Z <= this.target.Max.Z-max.Z; t.Z++ {
+				extra := 0
+				for _, cell := range cells {
+					if !this.cells.Has(Vector{cell.X + t.X, cell.Y + t.Y, cell.Z + t.Z}) {
+						extra++
+					}
+				}
+				if extra < best {
+					best = extra
+				}
+			}
+		}
+	}
+	return best
+}
+
+// cellsBounds computes the minimum and maximum coordinates of a non-empty list of cells.
+func cellsBounds(cells Cells) (*Vector, *Vector) {
+	min, max := cells[0].Vector.Clone(), cells[0].Vector.Clone()
+	for _, cell := range cells {
+		if cell.X < min.X {
+			min.X = cell.X
+		} else if cell.X > max.X {
+			max.X = cell.X
+		}
+		if cell.Y < min.Y {
+			min.Y = cell.Y
+		} else if cell.Y > max.Y {
+			max.Y = cell.Y
+		}
+		if cell.Z < min.Z {
+			min.Z = cell.Z
+		} else if cell.Z > max.Z {
+			max.Z = cell.Z
+		}
+	}
+	return min, max
+}
@@ -0,0 +1,64 @@
+package logic
+
+import (
+	"github.com/op/go-logging"
+	"testing"
+)
+
+func init() {
+	// Targets log their clean up at length.
+	logging.SetLevel(logging.CRITICAL, "communitrix")
+}
+
+func TestPlacementValidator(t *testing.T) {
+	// An L shaped silhouette, three cells on each arm, one cell thick.
+	target := pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{2, 0, 0}, Vector{0, 1, 0}, Vector{0, 2, 0}).CleanUp()
+	all := []SilhouettePolicy{SilhouetteIgnore, SilhouettePenalize, SilhouetteReject}
+	cases := []struct {
+		name     string
+		policies []SilhouettePolicy
+		unit     *Piece
+		piece    *Piece
+		reason   PlacementReason
+		penalty  int
+	}{
+		{"first piece", all, pieceOf(), pieceOf(Vector{5, 5, 5}), PlacementValid, 0},
+		{"along an arm", all, pieceOf(Vector{0, 0, 0}), pieceOf(Vector{1, 0, 0}, Vector{2, 0, 0}), PlacementValid, 0},
+		{"on both arms", all, pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}), pieceOf(Vector{0, 1, 0}, Vector{0, 2, 0}), PlacementValid, 0},
+		{"collision", all, pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}), pieceOf(Vector{1, 0, 0}), PlacementCollision, 0},
+		{"too long", all, pieceOf(Vector{0, 0, 0}), pieceOf(Vector{1, 0, 0}, Vector{2, 0, 0}, Vector{3, 0, 0}), PlacementOutOfBounds, 0},
+		{"too long backwards", all, pieceOf(Vector{0, 0, 0}, Vector{0, 1, 0}), pieceOf(Vector{0, -1, 0}, Vector{0, -2, 0}), PlacementOutOfBounds, 0},
+		{"too thick", all, pieceOf(Vector{0, 0, 0}), pieceOf(Vector{0, 0, 1}), PlacementOutOfBounds, 0},
+		{"off the silhouette, ignored", []SilhouettePolicy{SilhouetteIgnore}, pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}), pieceOf(Vector{1, 1, 0}), PlacementValid, 0},
+		{"off the silhouette, penalized", []SilhouettePolicy{SilhouettePenalize}, pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}), pieceOf(Vector{1, 1, 0}), PlacementValid, 1},
+		{"off the silhouette, rejected", []SilhouettePolicy{SilhouetteReject}, pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}), pieceOf(Vector{1, 1, 0}), PlacementOutsideTarget, 0},
+		{"far off the silhouette, penalized", []SilhouettePolicy{SilhouettePenalize}, pieceOf(Vector{0, 0, 0}), pieceOf(Vector{1, 1, 0}, Vector{2, 1, 0}, Vector{2, 2, 0}), PlacementValid, 3},
+		// The unit was already one cell off, the piece itself lies within the silhouette.
+		{"already off the silhouette", []SilhouettePolicy{SilhouettePenalize}, pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{1, 1, 0}), pieceOf(Vector{2, 0, 0}), PlacementValid, 0},
+	}
+	for _, c := range cases {
+		for _, policy := range c.policies {
+			placement := NewPlacementValidator(target, policy).Validate(c.unit.Clone(), c.piece.Clone())
+			if placement.Reason != c.reason || placement.Penalty != c.penalty {
+				t.Errorf("%s, policy %d: got reason %d with a penalty of %d, want reason %d with a penalty of %d", c.name, policy, placement.Reason, placement.Penalty, c.reason, c.penalty)
+			}
+			if placement.IsValid() != (c.reason == PlacementValid) {
+				t.Errorf("%s, policy %d: validity disagrees with reason %d", c.name, policy, placement.Reason)
+			}
+			if (placement.Reason.Message() == "") != placement.IsValid() {
+				t.Errorf("%s, policy %d: reason %d has message %q", c.name, policy, placement.Reason, placement.Reason.Message())
+			}
+		}
+	}
+}
+
+func TestSilhouettePolicyFromString(t *testing.T) {
+	for name, want := range map[string]SilhouettePolicy{"ignore": SilhouetteIgnore, "penalize": SilhouettePenalize, "reject": SilhouetteReject} {
+		if got, ok := SilhouettePolicyFromString(name); !ok || got != want {
+			t.Errorf("policy %q gives %d (%v), want %d", name, got, ok, want)
+		}
+	}
+	if _, ok := SilhouettePolicyFromString("Reject"); ok {
+		t.Error("policy names are case sensitive")
+	}
+}
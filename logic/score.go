@@ -0,0 +1,65 @@
+package logic
+
+// Score describes how well a piece matches a target.
+type Score struct {
+	Matched int `json:"matched"` // Cells present in both the piece and the target.
+	Extra   int `json:"extra"`   // Cells present in the piece but not in the target.
+	Missing int `json:"missing"` // Cells present in the target but not in the piece.
+}
+
+// Value sums up a score as a single number.
+func (this *Score) Value() int {
+	return this.Matched - this.Extra - this.Missing
+}
+
+// Compare computes the best score this piece can achieve against a target, up to rotation and translation.
+func (this *Piece) Compare(target *Piece) *Score {
+	best := &Score{Matched: 0, Extra: len(this.Content), Missing: len(target.Content)}
+	if this.IsEmpty() || target.IsEmpty() {
+		return best
+	}
+	cells := target.Voxels()
+	tMin, tMax := cellsBounds(target.Content)
+	rotated := make([]Vector, len(this.Content))
+	for _, orientation := range orientations {
+		for i, cell := range this.Content {
+			rotated[i] = orientation.Apply(*cell.Vector)
+		}
+		min, max := rotated[0], rotated[0]
+		for _, v := range rotated {
+			min = Vector{minInt(min.X, v.X), minInt(min.Y, v.Y), minInt(min.Z, v.Z)}
+			max = Vector{maxInt(max.X, v.X), maxInt(max.Y, v.Y), maxInt(max.Z, v.Z)}
+		}
+		// Try every translation making both bounding boxes overlap.
+		t := Vector{}
+		for t.X = tMin.X - max.X; t.X <= tMax.X-min.X; t.X++ {
+			for t.Y = tMin.Y - max.Y; t.Y <= tMax.Y-min.Y; t.Y++ {
+				for t.Z = tMin.Z - max.Z; t.Z <= tMax.Z-min.Z; t.Z++ {
+					matched := 0
+					for _, v := range rotated {
+						if cells.Has(Vector{v.X + t.X, v.Y + t.Y, v.Z + t.Z}) {
+							matched++
+						}
+					}
+					if matched > best.Matched {
+						best.Matched, best.Extra, best.Missing = matched, len(this.Content)-matched, len(target.Content)-matched
+					}
+				}
+			}
+		}
+	}
+	return best
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
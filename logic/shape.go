@@ -0,0 +1,140 @@
+package logic
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+)
+
+// Shape is a set of cell positions translated so their minimum coordinates are zero, and sorted. Two shapes
+// holding the same positions are always equal, whatever the order or translation they were built from.
+type Shape []Vector
+
+// NewShape normalizes a set of positions into a shape.
+func NewShape(vectors []Vector) Shape {
+	if len(vectors) == 0 {
+		return Shape{}
+	}
+	min := vectors[0]
+	for _, v := range vectors {
+		min = Vector{minInt(min.X, v.X), minInt(min.Y, v.Y), minInt(min.Z, v.Z)}
+	}
+	ret := make(Shape, len(vectors))
+	for i, v := range vectors {
+		ret[i] = Vector{v.X - min.X, v.Y - min.Y, v.Z - min.Z}
+	}
+	sort.Slice(ret, func(i, j int) bool { return lessVector(ret[i], ret[j]) })
+	return ret
+}
+
+func lessVector(a, b Vector) bool {
+	if a.X != b.X {
+		return a.X < b.X
+	} else if a.Y != b.Y {
+		return a.Y < b.Y
+	}
+	return a.Z < b.Z
+}
+
+// Orient gives this shape under another orientation.
+func (this Shape) Orient(o Orientation) Shape {
+	rotated := make([]Vector, len(this))
+	for i, v := range this {
+		rotated[i] = o.Apply(v)
+	}
+	return NewShape(rotated)
+}
+
+// Less orders shapes by size, then lexicographically.
+func (this Shape) Less(other Shape) bool {
+	if len(this) != len(other) {
+		return len(this) < len(other)
+	}
+	for i := range this {
+		if this[i] != other[i] {
+			return lessVector(this[i], other[i])
+		}
+	}
+	return false
+}
+
+// Equal tells whether two shapes hold the same positions.
+func (this Shape) Equal(other Shape) bool {
+	return len(this) == len(other) && !this.Less(other) && !other.Less(this)
+}
+
+// Hash digests a shape.
+func (this Shape) Hash() uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 4)
+	for _, v := range this {
+		for _, c := range [3]int{v.X, v.Y, v.Z} {
+			binary.LittleEndian.PutUint32(buf, uint32(int32(c)))
+			h.Write(buf)
+		}
+	}
+	return h.Sum64()
+}
+
+// Canonical gives the smallest of this shape's orientations, which is the same for all shapes equal up to rotation.
+func (this Shape) Canonical() Shape {
+	best := this
+	for _, o := range orientations[1:] {
+		if rotated := this.Orient(o); rotated.Less(best) {
+			best = rotated
+		}
+	}
+	return best
+}
+
+// Shape gives the positions of this piece's cells, regardless of where the piece is and of its cell values.
+func (this *Piece) Shape() Shape {
+	vectors := make([]Vector, len(this.Content))
+	for i, cell := range this.Content {
+		vectors[i] = *cell.Vector
+	}
+	return NewShape(vectors)
+}
+
+// Canonical gives the shape of this piece, normalized over rotation and translation.
+func (this *Piece) Canonical() Shape {
+	return this.Shape().Canonical()
+}
+
+// ShapeHash digests the shape of this piece. Pieces having the same shape up to rotation and translation have the same hash.
+func (this *Piece) ShapeHash() uint64 {
+	return this.Canonical().Hash()
+}
+
+// EqualShape tells whether two pieces have the same shape, up to rotation and translation.
+func (this *Piece) EqualShape(other *Piece) bool {
+	return len(this.Content) == len(other.Content) && this.Canonical().Equal(other.Canonical())
+}
+
+// Contains tells whether the shape of another piece fits inside this piece, up to rotation and translation.
+func (this *Piece) Contains(other *Piece) bool {
+	if other.IsEmpty() {
+		return true
+	} else if len(other.Content) > len(this.Content) {
+		return false
+	}
+	cells := this.Voxels()
+	shape := other.Shape()
+	for _, o := range orientations {
+		rotated := shape.Orient(o)
+		// Try every translation bringing the first cell of the other piece onto one of ours.
+		for at := range cells {
+			fits := true
+			for _, v := range rotated {
+				if !cells.Has(Vector{at.X + v.X - rotated[0].X, at.Y + v.Y - rotated[0].Y, at.Z + v.Z - rotated[0].Z}) {
+					fits = false
+					break
+				}
+			}
+			if fits {
+				return true
+			}
+		}
+	}
+	return false
+}
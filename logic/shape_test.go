@@ -0,0 +1,130 @@
+package logic
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// pieceOf builds a piece out of positions, all cells having the same value.
+func pieceOf(vectors ...Vector) *Piece {
+	ret := NewPiece(NewVectorFromValues(0, 0, 0), len(vectors))
+	for _, v := range vectors {
+		ret.AddCell(NewCellFromValues(v.X, v.Y, v.Z, 1))
+	}
+	return ret
+}
+
+// moved gives a copy of a piece, oriented, translated, and with its cells shuffled.
+func moved(rng *rand.Rand, piece *Piece, o Orientation) *Piece {
+	ret := piece.Clone().Orient(o).Translate(NewVectorFromValues(rng.Intn(21)-10, rng.Intn(21)-10, rng.Intn(21)-10))
+	rng.Shuffle(len(ret.Content), func(i, j int) { ret.Content[i], ret.Content[j] = ret.Content[j], ret.Content[i] })
+	return ret
+}
+
+// The tetracubes, each one being a distinct shape. Screws are mirror images of each other.
+var (
+	tetraI      = pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{2, 0, 0}, Vector{3, 0, 0})
+	tetraO      = pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{0, 1, 0}, Vector{1, 1, 0})
+	tetraT      = pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{2, 0, 0}, Vector{1, 1, 0})
+	tetraL      = pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{2, 0, 0}, Vector{2, 1, 0})
+	tetraS      = pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{1, 1, 0}, Vector{2, 1, 0})
+	tetraTripod = pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{0, 1, 0}, Vector{0, 0, 1})
+	tetraScrew  = pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}, Vector{1, 1, 0}, Vector{1, 1, 1})
+	tetraMirror = pieceOf(Vector{0, 0, 0}, Vector{-1, 0, 0}, Vector{-1, 1, 0}, Vector{-1, 1, 1})
+	tetracubes  = []*Piece{tetraI, tetraO, tetraT, tetraL, tetraS, tetraTripod, tetraScrew, tetraMirror}
+)
+
+func TestShapeIsInvariant(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for idx, piece := range tetracubes {
+		canonical, hash := piece.Canonical(), piece.ShapeHash()
+		for _, o := range Orientations() {
+			other := moved(rng, piece, o)
+			if got := other.Canonical(); !got.Equal(canonical) {
+				t.Errorf("tetracube %d: canonical shape is %v once moved, want %v", idx, got, canonical)
+			}
+			if got := other.ShapeHash(); got != hash {
+				t.Errorf("tetracube %d: hash is %x once moved, want %x", idx, got, hash)
+			}
+			if !other.EqualShape(piece) || !piece.EqualShape(other) {
+				t.Errorf("tetracube %d: not equal to itself once moved", idx)
+			}
+			if !other.Contains(piece) || !piece.Contains(other) {
+				t.Errorf("tetracube %d: does not contain itself once moved", idx)
+			}
+		}
+		// Cell values don't matter.
+		valued := piece.Clone()
+		for c, cell := range valued.Content {
+			cell.Value = c + 2
+		}
+		if !valued.EqualShape(piece) || valued.ShapeHash() != hash {
+			t.Errorf("tetracube %d: cell values change its shape", idx)
+		}
+	}
+}
+
+func TestShapeTellsShapesApart(t *testing.T) {
+	for a, piece := range tetracubes {
+		for b, other := range tetracubes {
+			if a == b {
+				continue
+			}
+			if piece.EqualShape(other) {
+				t.Errorf("tetracubes %d and %d have the same shape", a, b)
+			}
+			if piece.ShapeHash() == other.ShapeHash() {
+				t.Errorf("tetracubes %d and %d have the same hash", a, b)
+			}
+			if piece.Contains(other) {
+				t.Errorf("tetracube %d contains tetracube %d", a, b)
+			}
+		}
+	}
+	// Flat shapes can be flipped over, so their mirror images are the same.
+	flipped := pieceOf(Vector{0, 0, 0}, Vector{-1, 0, 0}, Vector{-1, 1, 0}, Vector{-2, 1, 0})
+	if !flipped.EqualShape(tetraS) {
+		t.Error("the S tetracube differs from its mirror image")
+	}
+	// Same sizes but different shapes.
+	if pieceOf(Vector{0, 0, 0}, Vector{1, 0, 0}).EqualShape(pieceOf(Vector{0, 0, 0}, Vector{2, 0, 0})) {
+		t.Error("a domino has the same shape as two separate cells")
+	}
+}
+
+func TestShapeContains(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cube := make([]Vector, 0, 27)
+	for x := 0; x < 3; x++ {
+		for y := 0; y < 3; y++ {
+			for z := 0; z < 3; z++ {
+				cube = append(cube, Vector{x, y, z})
+			}
+		}
+	}
+	big := pieceOf(cube...)
+	cases := []struct {
+		name  string
+		piece *Piece
+		other *Piece
+		want  bool
+	}{
+		{"empty", tetraI, pieceOf(), true},
+		{"cube holds the L", big, tetraL, true},
+		{"cube holds the screw", big, tetraScrew, true},
+		{"cube holds its mirror", big, tetraMirror, true},
+		{"cube is too short for the I", big, tetraI, false},
+		{"L holds a bent tromino", tetraL, pieceOf(Vector{0, 0, 0}, Vector{0, 1, 0}, Vector{1, 1, 0}), true},
+		{"I has no bent tromino", tetraI, pieceOf(Vector{0, 0, 0}, Vector{0, 1, 0}, Vector{1, 1, 0}), false},
+		{"larger pieces never fit", tetraT, pieceOf(cube[:5]...), false},
+		{"screw has no tripod", tetraScrew, tetraTripod, false},
+	}
+	for _, c := range cases {
+		for _, o := range Orientations() {
+			if got := moved(rng, c.piece, o).Contains(moved(rng, c.other, Identity)); got != c.want {
+				t.Errorf("%s: contains is %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+}
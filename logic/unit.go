@@ -13,3 +13,20 @@ func NewEmptyUnit() *Unit {
 		Moves: make(map[string][]int),
 	}
 }
+
+// Clone gives a deep copy of this unit, which can be handed over while the original keeps being played on.
+func (this *Unit) Clone() *Unit {
+	moves := make(map[string][]int, len(this.Moves))
+	for uuid, ids := range this.Moves {
+		moves[uuid] = append([]int(nil), ids...)
+	}
+	return &Unit{Piece: this.Piece.Clone(), Moves: moves}
+}
+
+func (this Units) Clone() Units {
+	ret := make(Units, len(this))
+	for i, unit := range this {
+		ret[i] = unit.Clone()
+	}
+	return ret
+}
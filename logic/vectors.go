@@ -12,19 +12,10 @@ func (this Vectors) Clone() Vectors {
 	return ret
 }
 
-func (this Vectors) Shuffle() Vectors {
+func (this Vectors) Shuffle(rng *rand.Rand) Vectors {
 	for i := len(this) - 1; i > 0; i-- {
-		j := rand.Intn(i)
+		j := rng.Intn(i)
 		this[i], this[j] = this[j], this[i]
 	}
 	return this
 }
-
-func (this Vectors) CollidesWith(v *Vector) bool {
-	for _, cell := range this {
-		if cell.CollidesWith(v) {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,93 @@
+package logic
+
+// Voxels is a set of positions, answering membership in constant time.
+type Voxels map[Vector]struct{}
+
+// NewVoxels is the Voxels default constructor.
+func NewVoxels(capacity int) Voxels {
+	return make(Voxels, capacity)
+}
+
+// NewVoxelsFromCells builds the set of positions occupied by cells.
+func NewVoxelsFromCells(cells Cells) Voxels {
+	ret := make(Voxels, len(cells))
+	for _, cell := range cells {
+		ret[*cell.Vector] = struct{}{}
+	}
+	return ret
+}
+
+// NewVoxelsFromVectors builds the set of positions of vectors.
+func NewVoxelsFromVectors(vectors Vectors) Voxels {
+	ret := make(Voxels, len(vectors))
+	for _, v := range vectors {
+		ret[*v] = struct{}{}
+	}
+	return ret
+}
+
+func (this Voxels) Add(v Vector)      { this[v] = struct{}{} }
+func (this Voxels) Remove(v Vector)   { delete(this, v) }
+func (this Voxels) Has(v Vector) bool { _, ok := this[v]; return ok }
+func (this Voxels) Len() int          { return len(this) }
+func (this Voxels) IsEmpty() bool     { return len(this) == 0 }
+
+func (this Voxels) Clone() Voxels {
+	ret := make(Voxels, len(this))
+	for v := range this {
+		ret[v] = struct{}{}
+	}
+	return ret
+}
+
+// Union gives the positions present in either set.
+func (this Voxels) Union(other Voxels) Voxels {
+	ret := make(Voxels, len(this)+len(other))
+	for v := range this {
+		ret[v] = struct{}{}
+	}
+	for v := range other {
+		ret[v] = struct{}{}
+	}
+	return ret
+}
+
+// Intersection gives the positions present in both sets.
+func (this Voxels) Intersection(other Voxels) Voxels {
+	small, big := this, other
+	if len(small) > len(big) {
+		small, big = big, small
+	}
+	ret := make(Voxels)
+	for v := range small {
+		if big.Has(v) {
+			ret[v] = struct{}{}
+		}
+	}
+	return ret
+}
+
+// Difference gives the positions present in this set but not in the other one.
+func (this Voxels) Difference(other Voxels) Voxels {
+	ret := make(Voxels)
+	for v := range this {
+		if !other.Has(v) {
+			ret[v] = struct{}{}
+		}
+	}
+	return ret
+}
+
+// Intersects tells whether both sets share at least one position, without building their intersection.
+func (this Voxels) Intersects(other Voxels) bool {
+	small, big := this, other
+	if len(small) > len(big) {
+		small, big = big, small
+	}
+	for v := range small {
+		if big.Has(v) {
+			return true
+		}
+	}
+	return false
+}
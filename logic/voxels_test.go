@@ -0,0 +1,127 @@
+package logic
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// randomPiece fills a cube of the given side with cells, each position being taken with the given probability.
+func randomPiece(rng *rand.Rand, side int, density float64) *Piece {
+	ret := NewPiece(NewVectorFromValues(side, side, side), 0)
+	for x := 0; x < side; x++ {
+		for y := 0; y < side; y++ {
+			for z := 0; z < side; z++ {
+				if rng.Float64() < density {
+					ret.AddCell(NewCellFromValues(x, y, z, 1))
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// linearCollidesWith is how collisions were checked before voxel sets, comparing every pair of cells.
+func linearCollidesWith(unit, piece *Piece) bool {
+	for _, c := range piece.Content {
+		for _, cell := range unit.Content {
+			if cell.Vector.CollidesWith(c.Vector) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestVoxelsOperations(t *testing.T) {
+	a := NewVoxelsFromVectors(Vectors{{0, 0, 0}, {1, 0, 0}, {2, 0, 0}})
+	b := NewVoxelsFromVectors(Vectors{{2, 0, 0}, {3, 0, 0}})
+	if got := a.Union(b).Len(); got != 4 {
+		t.Errorf("union has %d positions, want 4", got)
+	}
+	if got := a.Intersection(b); got.Len() != 1 || !got.Has(Vector{2, 0, 0}) {
+		t.Errorf("intersection is %v, want only {2 0 0}", got)
+	}
+	if got := a.Difference(b); got.Len() != 2 || got.Has(Vector{2, 0, 0}) {
+		t.Errorf("difference is %v, want {0 0 0} and {1 0 0}", got)
+	}
+	if !a.Intersects(b) || a.Intersects(NewVoxelsFromVectors(Vectors{{9, 9, 9}})) {
+		t.Error("intersects disagrees with intersection")
+	}
+	c := a.Clone()
+	c.Remove(Vector{0, 0, 0})
+	if !a.Has(Vector{0, 0, 0}) || c.Len() != 2 {
+		t.Error("clones should not share their positions")
+	}
+}
+
+func TestPieceCollidesWith(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	unit := randomPiece(rng, 4, 0.3)
+	for trial := 0; trial < 500; trial++ {
+		// Units keep growing and moving between checks, their positions must follow.
+		switch rng.Intn(3) {
+		case 0:
+			unit.Translate(NewVectorFromValues(rng.Intn(3)-1, rng.Intn(3)-1, rng.Intn(3)-1))
+		case 1:
+			unit.AddCell(NewCellFromValues(rng.Intn(6)-1, rng.Intn(6)-1, rng.Intn(6)-1, 1))
+		}
+		piece := randomPiece(rng, 2, 0.5)
+		piece.Translate(NewVectorFromValues(rng.Intn(5)-1, rng.Intn(5)-1, rng.Intn(5)-1))
+		if got, want := unit.CollidesWith(piece), linearCollidesWith(unit, piece); got != want {
+			t.Fatalf("trial %d: collision is %v, want %v", trial, got, want)
+		}
+	}
+	if got, want := unit.Voxels(), NewVoxelsFromCells(unit.Content); got.Len() != want.Len() || got.Difference(want).Len() != 0 {
+		t.Fatalf("voxels are %v, want %v", got, want)
+	}
+}
+
+// benchmarkSides are the target dimensions collisions and lookups are measured on.
+var benchmarkSides = []int{16, 32}
+
+// collisionFixture gives a half filled unit, and a piece lying just outside of it so every cell gets checked.
+func collisionFixture(side int) (*Piece, *Piece) {
+	rng := rand.New(rand.NewSource(int64(side)))
+	unit, piece := randomPiece(rng, side, 0.5), randomPiece(rng, 4, 0.5)
+	piece.Translate(NewVectorFromValues(side, 0, 0))
+	return unit, piece
+}
+
+func BenchmarkCollision(b *testing.B) {
+	for _, side := range benchmarkSides {
+		unit, piece := collisionFixture(side)
+		b.Run(fmt.Sprintf("linear/%d", side), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				linearCollidesWith(unit, piece)
+			}
+		})
+		b.Run(fmt.Sprintf("voxels/%d", side), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				unit.CollidesWith(piece)
+			}
+		})
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, side := range benchmarkSides {
+		target := randomPiece(rand.New(rand.NewSource(int64(side))), side, 0.5)
+		probe := Vector{side / 2, side / 2, side}
+		b.Run(fmt.Sprintf("linear/%d", side), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for _, cell := range target.Content {
+					if cell.Vector.CollidesWith(&probe) {
+						break
+					}
+				}
+			}
+		})
+		voxels := target.Voxels()
+		b.Run(fmt.Sprintf("voxels/%d", side), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				voxels.Has(probe)
+			}
+		})
+	}
+}
@@ -3,11 +3,21 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/hickscorp/communitrix-server/account"
+	"github.com/hickscorp/communitrix-server/chat"
+	"github.com/hickscorp/communitrix-server/gen"
+	"github.com/hickscorp/communitrix-server/logic"
+	"github.com/hickscorp/communitrix-server/transport"
 	"github.com/op/go-logging"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
@@ -24,15 +34,53 @@ func init() {
 func main() {
 	// Allows to parse a single parameter, the port.
 	config.Port = flag.Int("port", 9003, "Port to serve on.")
+	config.WebSocketPort = flag.Int("wsPort", 0, "Port to serve websocket clients on, zero meaning disabled.")
 	config.HubCommandBufferSize = flag.Int("hubCommandBuffer", 2048, "Size of the hub command queue buffer.")
 	config.ClientSendBufferSize = flag.Int("clientSendBufferSize", 8, "Size of the client send queue buffer.")
-	config.Seed = flag.Int64("seed", 18021982, "The random seed to use.")
+	config.Seed = flag.Int64("seed", 18021982, "The random seed combat seeds are drawn from.")
+	config.VoteMajority = flag.Float64("voteMajority", 0.5, "Ratio of the other players that must vote against a player for the vote to pass.")
+	config.VoteOutcome = flag.String("voteOutcome", VoteOutcomeKick, "What happens to a player when a vote against him passes [kick|skip].")
+	config.TurnDuration = flag.Duration("turnDuration", 60*time.Second, "How long players have to play each turn, zero meaning forever.")
+	config.TimeoutAction = flag.String("timeoutAction", TimeoutActionSkip, "What happens to idle players once a turn times out [skip|autoplay].")
+	config.SessionGracePeriod = flag.Duration("sessionGracePeriod", 2*time.Minute, "How long disconnected players keep their combat seat.")
+	config.ShutdownDeadline = flag.Duration("shutdownDeadline", 2*time.Minute, "How long running combats get to finish when the server stops.")
+	config.AccountsPath = flag.String("accounts", "data/accounts.json", "The file in which player accounts are stored.")
+	config.ReplayPath = flag.String("replays", "data/replays", "The directory in which combat replays are recorded, empty meaning disabled.")
+	config.ChatBurst = flag.Int("chatBurst", 5, "How many chat messages players can send in a row.")
+	config.ChatInterval = flag.Duration("chatInterval", 2*time.Second, "How long players wait to earn one more chat message.")
+	wsOrigins := flag.String("wsOrigins", "", "Comma separated origins browsers may connect from over websockets, \"*\" meaning any, empty meaning this host only.")
+	chatWords := flag.String("chatWords", "", "A file listing the words masked in chat messages, one per line, empty meaning none.")
+	polycubes := flag.String("polycubes", "catalogs/polycubes.json", "The catalog of shapes used by the polycubes generation strategy.")
+	silhouette := flag.String("silhouette", "ignore", "How cells played outside of the target shape are handled [ignore|penalize|reject].")
 	logLevel := flag.String("logLevel", "WARNING", "Log level [DEBUG|INFO|WARNING|ERROR|CRITICAL].")
 	flag.Parse()
 
 	config.LogLevel, _ = logging.LogLevel(*logLevel)
 	logging.SetLevel(config.LogLevel, "communitrix")
 
+	if *config.VoteOutcome != VoteOutcomeKick && *config.VoteOutcome != VoteOutcomeSkip {
+		log.Error("Unknown vote outcome: %s.", *config.VoteOutcome)
+		os.Exit(1)
+	}
+	if *config.TimeoutAction != TimeoutActionSkip && *config.TimeoutAction != TimeoutActionAutoPlay {
+		log.Error("Unknown timeout action: %s.", *config.TimeoutAction)
+		os.Exit(1)
+	}
+	if *config.ChatBurst < 1 || *config.ChatInterval <= 0 {
+		log.Error("Chat burst and interval must be positive.")
+		os.Exit(1)
+	}
+	for _, origin := range strings.Split(*wsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.WebSocketOrigins = append(config.WebSocketOrigins, origin)
+		}
+	}
+	var ok bool
+	if config.SilhouettePolicy, ok = logic.SilhouettePolicyFromString(*silhouette); !ok {
+		log.Error("Unknown silhouette policy: %s.", *silhouette)
+		os.Exit(1)
+	}
+
 	log.Debug("Booting on up to %d CPUs...", runtime.NumCPU())
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
@@ -51,17 +99,74 @@ func main() {
 	// Close the listener when the application closes.
 	defer listener.Close()
 	log.Info("Server is ready on %s.", addr)
+	// Load player accounts.
+	store, err := account.NewFileStore(*config.AccountsPath)
+	if err != nil {
+		log.Error("Error loading accounts: %s", err.Error())
+		os.Exit(1)
+	}
+	// Load the polycube catalog. Without it, only the carving generation strategy is available.
+	if config.Polycubes, err = gen.LoadCatalog(*polycubes); err != nil {
+		log.Warning("Error loading the polycube catalog: %s", err.Error())
+	}
+	// Load the chat filter.
+	config.ChatFilter = chat.NoFilter
+	if *chatWords != "" {
+		if config.ChatFilter, err = chat.LoadWordFilter(*chatWords); err != nil {
+			log.Error("Error loading the chat words: %s", err.Error())
+			os.Exit(1)
+		}
+	}
 	// Create and run our hub.
-	hub := NewHub()
+	hub := NewHub(store)
 	go hub.Run()
-	for {
-		// Listen for an incoming connection.
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Error("Error accepting new client: %s", err.Error())
-			os.Exit(1)
+	// Once stopping, listeners failing are expected.
+	stopping := make(chan bool)
+	// Serve browser clients as well when asked to.
+	var wsServer *http.Server
+	if *config.WebSocketPort != 0 {
+		wsServer = &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", *config.WebSocketPort), Handler: hub}
+		go func() {
+			log.Info("Websocket server is ready on %s.", wsServer.Addr)
+			if err := wsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("Error listening for websocket clients: %s", err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+	go func() {
+		for {
+			// Listen for an incoming connection.
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-stopping:
+					return
+				default:
+				}
+				log.Error("Error accepting new client: %s", err.Error())
+				os.Exit(1)
+			}
+			// Handle connections in a new goroutine.
+			go hub.HandleClient(transport.NewLine(conn))
 		}
-		// Handle connections in a new goroutine.
-		go hub.HandleClient(conn)
+	}()
+
+	// Stop gracefully when asked to, and right away when asked twice.
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Info("Received %s, no longer accepting clients.", sig)
+	close(stopping)
+	listener.Close()
+	if wsServer != nil {
+		wsServer.Close()
 	}
+	go func() {
+		<-signals
+		log.Warning("Received a second signal, exiting right away.")
+		os.Exit(1)
+	}()
+	hub.Shutdown(*config.ShutdownDeadline)
+	log.Info("Server stopped.")
 }
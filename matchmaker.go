@@ -0,0 +1,168 @@
+package main
+
+import (
+	"github.com/hickscorp/communitrix-server/i"
+	"sort"
+	"time"
+)
+
+const (
+	matchmakingInterval       = 1 * time.Second  // How often queued players are grouped.
+	matchmakingStatusInterval = 5 * time.Second  // How often queued players are told about their status.
+	matchmakingLevelSpread    = 2                // The level difference tolerated right away within a group.
+	matchmakingSpreadPeriod   = 10 * time.Second // Waiting that long allows one more level of difference.
+)
+
+// Matchmaker groups queued players into combats.
+type Matchmaker struct {
+	tickets []*ticket // Queued players, oldest first.
+}
+
+// ticket is a queued player along with his preferences.
+type ticket struct {
+	player     i.Player
+	requestID  string    // The request the player queued with.
+	players    int       // The number of players the combat should have.
+	difficulty string    // The difficulty of the combat.
+	since      time.Time // When the player was queued.
+	notifiedAt time.Time // When the player was last told about his status.
+}
+
+func NewMatchmaker() *Matchmaker {
+	return &Matchmaker{tickets: make([]*ticket, 0)}
+}
+
+// IsQueued checks whether a player is waiting for a combat.
+func (this *Matchmaker) IsQueued(player i.Player) bool {
+	return this.index(player) >= 0
+}
+func (this *Matchmaker) index(player i.Player) int {
+	for idx, t := range this.tickets {
+		if t.player == player {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Enqueue adds a player to the queue.
+func (this *Matchmaker) Enqueue(player i.Player, requestID string, players int, difficulty string, now time.Time) *ticket {
+	t := &ticket{
+		player:     player,
+		requestID:  requestID,
+		players:    players,
+		difficulty: difficulty,
+		since:      now,
+		notifiedAt: now,
+	}
+	this.tickets = append(this.tickets, t)
+	return t
+}
+
+// Dequeue removes a player from the queue. It returns false when the player wasn't queued.
+func (this *Matchmaker) Dequeue(player i.Player) bool {
+	idx := this.index(player)
+	if idx < 0 {
+		return false
+	}
+	this.tickets = append(this.tickets[:idx], this.tickets[idx+1:]...)
+	return true
+}
+
+// Clear empties the queue.
+func (this *Matchmaker) Clear() {
+	this.tickets = make([]*ticket, 0)
+}
+
+// Position gives the rank of a ticket among the tickets sharing its preferences, along with how many they are.
+func (this *Matchmaker) Position(t *ticket) (int, int) {
+	position, waiting := 0, 0
+	for _, other := range this.tickets {
+		if other.players != t.players || other.difficulty != t.difficulty {
+			continue
+		}
+		waiting++
+		if other == t {
+			position = waiting
+		}
+	}
+	return position, waiting
+}
+
+// Stale lists the tickets whose players should be reminded about their status, and marks them as notified.
+func (this *Matchmaker) Stale(now time.Time) []*ticket {
+	ret := make([]*ticket, 0)
+	for _, t := range this.tickets {
+		if now.Sub(t.notifiedAt) >= matchmakingStatusInterval {
+			t.notifiedAt = now
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// Match groups tickets sharing the same preferences and close enough in level, and removes them from the queue.
+func (this *Matchmaker) Match(now time.Time) [][]*ticket {
+	// Bucket tickets by preferences.
+	type preferences struct {
+		players    int
+		difficulty string
+	}
+	buckets := make(map[preferences][]*ticket)
+	for _, t := range this.tickets {
+		key := preferences{t.players, t.difficulty}
+		buckets[key] = append(buckets[key], t)
+	}
+
+	groups := make([][]*ticket, 0)
+	matched := make(map[*ticket]bool)
+	for key, bucket := range buckets {
+		if len(bucket) < key.players {
+			continue
+		}
+		// Among players of the same level, the oldest tickets come first.
+		sort.Stable(ticketsByLevel(bucket))
+		for idx := 0; idx+key.players <= len(bucket); {
+			group := bucket[idx : idx+key.players]
+			// The longest waiting player of the group decides how much level difference is tolerated.
+			spread := 0
+			for _, t := range group {
+				if s := t.levelSpread(now); s > spread {
+					spread = s
+				}
+			}
+			if group[len(group)-1].player.Level()-group[0].player.Level() > spread {
+				idx++
+				continue
+			}
+			for _, t := range group {
+				matched[t] = true
+			}
+			groups = append(groups, group)
+			idx += key.players
+		}
+	}
+
+	// Keep the unmatched tickets in their original order.
+	remaining := make([]*ticket, 0, len(this.tickets))
+	for _, t := range this.tickets {
+		if !matched[t] {
+			remaining = append(remaining, t)
+		}
+	}
+	this.tickets = remaining
+	return groups
+}
+
+// levelSpread is the level difference this ticket tolerates, growing as its player waits.
+func (this *ticket) levelSpread(now time.Time) int {
+	return matchmakingLevelSpread + int(now.Sub(this.since)/matchmakingSpreadPeriod)
+}
+
+type ticketsByLevel []*ticket
+
+func (this ticketsByLevel) Len() int      { return len(this) }
+func (this ticketsByLevel) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this ticketsByLevel) Less(i, j int) bool {
+	return this[i].player.Level() < this[j].player.Level()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/hickscorp/communitrix-server/cmd/cbt"
+	"github.com/hickscorp/communitrix-server/i"
+	"github.com/hickscorp/communitrix-server/replay"
+	"time"
+)
+
+// replayMaxPause is the longest a replay waits between two recorded events.
+const replayMaxPause = 2 * time.Second
+
+// NewReplayCombat rebuilds a recorded combat, ready to be played again. Its players are seated, but never connected.
+// They get fresh UUIDs, as the recorded ones might belong to someone connected, the viewer included.
+func NewReplayCombat(entries []*replay.Entry) *Combat {
+	start := entries[0]
+	combat := NewCombat(len(start.Players), len(start.Players), 0, start.Rules.TimeoutAction, start.Profile)
+	combat.seed = start.Seed
+	combat.voteMajority = start.Rules.VoteMajority
+	combat.voteOutcome = start.Rules.VoteOutcome
+	combat.silhouettePolicy = start.Rules.SilhouettePolicy
+	// Replays of replays are of no use.
+	combat.replayPath = ""
+	combat.launching = true
+	combat.state = newCombatState()
+	for index, p := range start.Players {
+		player := NewPlayer(nil)
+		player.username = p.Username
+		player.Disconnect()
+		combat.players[player.uuid] = player
+		combat.state.playerIndices[player.uuid] = index
+	}
+	return combat
+}
+
+// Replay runs this combat again from its recorded entries, for a viewer already registered as a spectator.
+func (this *Combat) Replay(entries []*replay.Entry, viewer i.Player) {
+	// The combat owns its list of players once running. Entries refer to them by their recorded UUIDs.
+	players := make(map[string]i.Player, len(this.players))
+	for uuid, index := range this.state.playerIndices {
+		players[entries[0].Players[index].UUID] = this.players[uuid]
+	}
+	done := make(chan bool)
+	go func() {
+		this.Run()
+		close(done)
+	}()
+	// Feed the combat with everything that happened, at the pace it happened.
+	elapsed := time.Duration(0)
+	for _, entry := range entries {
+		pause := entry.Elapsed - elapsed
+		if pause > replayMaxPause {
+			pause = replayMaxPause
+		}
+		elapsed = entry.Elapsed
+		select {
+		case <-time.After(pause):
+		case <-done:
+			return
+		}
+		// Nobody is watching anymore.
+		if viewer.Combat() != this {
+			break
+		}
+		cmd := replayCommand(entry, players)
+		if cmd == nil {
+			continue
+		}
+		select {
+		case this.commandQueue <- cmd:
+		case <-done:
+			return
+		}
+	}
+	// The combat should be over by now, unless its replay was cut short.
+	select {
+	case this.commandQueue <- cbt.Wrap(cbt.Abort{Reason: "The replay is over."}):
+	case <-done:
+	}
+}
+
+// replayCommand converts a replay entry back to the combat command it was recorded from.
+func replayCommand(entry *replay.Entry, players map[string]i.Player) *cbt.Base {
+	player, ok := players[entry.PlayerUUID]
+	if !ok && (entry.Type == replay.EntryPlayTurn || entry.Type == replay.EntryVote || entry.Type == replay.EntryLeave) {
+		log.Warning("A replay entry references an unknown player %s.", entry.PlayerUUID)
+		return nil
+	}
+	switch entry.Type {
+	case replay.EntryStart:
+		// The combat plays on its own copies, as the entries stay around for as long as the replay runs.
+		return cbt.Wrap(cbt.Start{Target: entry.Target.Clone(), Pieces: entry.Pieces.Clone(), Units: entry.Units.Clone()})
+	case replay.EntryPlayTurn:
+		if entry.Rotation == nil || entry.Translation == nil {
+			return nil
+		}
+		return cbt.Wrap(cbt.PlayTurn{
+			Player:      player,
+			PieceIndex:  entry.PieceIndex,
+			Rotation:    entry.Rotation,
+			Translation: entry.Translation,
+		})
+	case replay.EntryVote:
+		against, ok := players[entry.Against]
+		if !ok {
+			return nil
+		}
+		return cbt.Wrap(cbt.Vote{Player: player, PlayerID: against.UUID()})
+	case replay.EntryLeave:
+		return cbt.Wrap(cbt.RemovePlayer{Player: player})
+	case replay.EntryTurnTimeout:
+		return cbt.Wrap(cbt.TurnTimeout{})
+	}
+	return nil
+}
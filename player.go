@@ -1,17 +1,16 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/hickscorp/communitrix-server/cmd/cbt"
 	"github.com/hickscorp/communitrix-server/cmd/rx"
 	"github.com/hickscorp/communitrix-server/cmd/tx"
 	"github.com/hickscorp/communitrix-server/i"
-	"github.com/hickscorp/communitrix-server/logic"
+	"github.com/hickscorp/communitrix-server/protocol"
 	"github.com/hickscorp/communitrix-server/util"
 	"math/rand"
-	"net"
 	"sync"
 	"time"
 )
@@ -30,43 +29,172 @@ func NextPlayerUUID() int64 {
 
 // Player is the base struct representing connected entities.
 type Player struct {
-	mutex        sync.Mutex    // The lock for this player.
-	uuid         string        // The player unique identifier on the server.
-	username     string        // The username the player has picked.
-	level        int           // This player's level.
-	connection   net.Conn      // The player connection socket itself.
-	commandQueue chan *tx.Base // Outbound messages are in a buffered channel.
-	exit         chan bool     // Signal exit.
-	combat       i.Combat      // The combat the player is currently in.
+	mutex           sync.Mutex    // The lock for this player.
+	uuid            string        // The player unique identifier on the server.
+	username        string        // The username the player has picked.
+	level           int           // This player's level.
+	account         string        // The account the player is logged into, if any.
+	connection      i.Transport   // The player connection itself.
+	commandQueue    chan *tx.Base // Outbound messages are in a buffered channel.
+	exit            chan bool     // Signal the running write loop to exit.
+	stopped         chan bool     // Closed once the running write loop has exited.
+	combat          i.Combat      // The combat the player is currently in.
+	token           string        // The secret allowing to resume this player's session after a disconnection.
+	sessionMutex    sync.Mutex    // The lock protecting the connection state.
+	connected       bool          // Whether a connection is currently bound to this player.
+	delegate        *Player       // The player this connection was resumed into, if any.
+	protocolVersion int           // The protocol version negotiated with the client.
 }
 
 func (this *Player) UUID() string                { return this.uuid }
 func (this *Player) Username() string            { return this.username }
 func (this *Player) SetUsername(username string) { this.username = username }
 func (this *Player) Level() int                  { return this.level }
-func (this *Player) Connection() net.Conn        { return this.connection }
-func (this *Player) Notify(cmd *tx.Base)         { this.commandQueue <- cmd }
-func (this *Player) Combat() i.Combat            { return this.combat }
+func (this *Player) SetLevel(level int)          { this.level = level }
+func (this *Player) Token() string               { return this.token }
 
-func NewPlayer(connection net.Conn) *Player {
+// Account is read by combats when recording, while the hub logs players in and out.
+func (this *Player) Account() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.account
+}
+func (this *Player) SetAccount(account string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.account = account
+}
+
+func NewPlayer(connection i.Transport) *Player {
 	return &Player{
-		mutex:        sync.Mutex{},
-		uuid:         fmt.Sprintf("CLI%d", NextPlayerUUID()),
-		connection:   connection,
-		commandQueue: make(chan *tx.Base, *config.ClientSendBufferSize),
-		exit:         make(chan bool, 1),
-		combat:       nil,
+		mutex:           sync.Mutex{},
+		uuid:            fmt.Sprintf("CLI%d", NextPlayerUUID()),
+		connection:      connection,
+		commandQueue:    make(chan *tx.Base, *config.ClientSendBufferSize),
+		combat:          nil,
+		token:           newSessionToken(),
+		protocolVersion: protocol.LegacyVersion,
+		connected:       true,
 	}
 }
-func StartNewPlayer(hubQueue chan<- *rx.Base, connection net.Conn) {
+func StartNewPlayer(hubQueue chan<- *rx.Base, connection i.Transport) {
 	player := NewPlayer(connection)
 	// Send our welcome message.
-	player.commandQueue <- tx.Wrap(tx.Welcome{Message: "Hi there!"})
+	player.commandQueue <- tx.Wrap(tx.Welcome{
+		Message:    "Hi there!",
+		Version:    protocol.CurrentVersion,
+		MinVersion: protocol.MinVersion,
+	})
 	// Start the writing loop thread, then start reading from the connection.
-	go player.writeLoop()
+	player.startWriting()
 	player.readLoop(hubQueue)
 }
 
+// newSessionToken generates an unguessable session token.
+func newSessionToken() string {
+	buf := make([]byte, 16)
+	if _, err := crand.Read(buf); err != nil {
+		log.Error("Unable to generate a session token: %s", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Notify queues a message for this player. Messages are dropped while the player is disconnected.
+func (this *Player) Notify(cmd *tx.Base) {
+	if this.IsConnected() {
+		this.commandQueue <- cmd
+	}
+}
+
+// TryNotify never waits for the player to catch up, broadcasts cannot be held up by a single stalled client.
+func (this *Player) TryNotify(cmd *tx.Base) bool {
+	if !this.IsConnected() {
+		return false
+	}
+	select {
+	case this.commandQueue <- cmd:
+		return true
+	default:
+		return false
+	}
+}
+
+func (this *Player) Connection() i.Transport {
+	this.sessionMutex.Lock()
+	defer this.sessionMutex.Unlock()
+	return this.connection
+}
+func (this *Player) IsConnected() bool {
+	this.sessionMutex.Lock()
+	defer this.sessionMutex.Unlock()
+	return this.connected
+}
+func (this *Player) Disconnect() {
+	this.sessionMutex.Lock()
+	defer this.sessionMutex.Unlock()
+	this.connected = false
+}
+
+// Resume binds the connection of a freshly connected player to this one, which lost its own connection.
+func (this *Player) Resume(from i.Player) bool {
+	other := from.(*Player)
+	if other == this {
+		return false
+	}
+	this.sessionMutex.Lock()
+	if this.connected {
+		this.sessionMutex.Unlock()
+		return false
+	}
+	// Drop whatever was queued before the disconnection, a snapshot will follow.
+	for len(this.commandQueue) > 0 {
+		<-this.commandQueue
+	}
+	this.connection, this.connected = other.connection, true
+	this.protocolVersion = other.protocolVersion
+	this.sessionMutex.Unlock()
+	// From now on, the fresh player's read loop works on behalf of this player.
+	other.sessionMutex.Lock()
+	other.delegate, other.connected = this, false
+	other.sessionMutex.Unlock()
+	// Only one loop may write to a connection, so wait for the fresh player's one to be gone.
+	stopped := other.stopWriting()
+	go func() {
+		<-stopped
+		this.startWriting()
+	}()
+	return true
+}
+
+// startWriting spawns a write loop on the current connection, along with its own exit signals.
+func (this *Player) startWriting() {
+	this.sessionMutex.Lock()
+	defer this.sessionMutex.Unlock()
+	this.exit, this.stopped = make(chan bool, 1), make(chan bool)
+	go this.writeLoop(this.connection, this.exit, this.stopped)
+}
+
+// stopWriting asks the running write loop to exit, and returns a channel closed once it has.
+func (this *Player) stopWriting() <-chan bool {
+	this.sessionMutex.Lock()
+	defer this.sessionMutex.Unlock()
+	select {
+	case this.exit <- true:
+	default:
+	}
+	return this.stopped
+}
+
+// session returns the player currently bound to this player's connection.
+func (this *Player) session() *Player {
+	this.sessionMutex.Lock()
+	defer this.sessionMutex.Unlock()
+	if this.delegate != nil {
+		return this.delegate
+	}
+	return this
+}
+
 func (this *Player) AsSendable() util.MapHelper {
 	return util.MapHelper{
 		"uuid":     this.uuid,
@@ -75,13 +203,18 @@ func (this *Player) AsSendable() util.MapHelper {
 	}
 }
 
+func (this *Player) Combat() i.Combat {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.combat
+}
 func (this *Player) IsInCombat() bool {
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
-	return this.Combat != nil
+	return this.combat != nil
 }
-func (this *Player) JoinCombat(combat i.Combat) {
-	combat.Notify(cbt.Wrap(cbt.AddPlayer{Player: this}))
+func (this *Player) JoinCombat(combat i.Combat, requestID string) {
+	combat.Notify(cbt.Reply(requestID, cbt.AddPlayer{Player: this}))
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
 	this.combat = combat
@@ -91,73 +224,240 @@ func (this *Player) LeaveCombat() {
 	defer this.mutex.Unlock()
 	if this.combat != nil {
 		this.combat.Notify(cbt.Wrap(cbt.RemovePlayer{Player: this}))
+		this.combat = nil
+	}
+}
+
+func (this *Player) SpectateCombat(combat i.Combat, requestID string) {
+	combat.Notify(cbt.Reply(requestID, cbt.AddSpectator{Player: this}))
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.combat = combat
+}
+
+// ForgetCombat drops the reference to a combat which is over, unless the player already moved on to another one.
+func (this *Player) ForgetCombat(combat i.Combat) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.combat == combat {
+		this.combat = nil
 	}
 }
 
 // CommandFromPacket processes a JSON-formated payload and attempts to transform it into a hub command.
 func (this *Player) CommandFromPacket(line []byte) *rx.Base {
-	// Deserialize the line to a util.MapHelper.
-	var rec util.MapHelper
-	if err := json.Unmarshal(line, &rec); err != nil {
-		log.Warning("[comms] Player %s has sent a packet we are unable to unmarshall: %s - %s.", this.uuid, err, line)
+	// Decode the line to its typed packet.
+	id := protocol.RequestID(line)
+	packet, perr := protocol.Decode(line, this.protocolVersion)
+	if perr != nil {
+		log.Warning("[comms] Player %s has sent an invalid packet: %s - %s.", this.uuid, perr, line)
+		this.commandQueue <- tx.Reply(id, tx.Error{
+			Code:   422,
+			Reason: "The command you sent could not be understood by the server: " + perr.Error(),
+			Field:  perr.Path,
+		})
 		return nil
 	}
 
-	typ := rec.String("type")
-	switch typ {
+	cmd := this.commandFromPacket(id, packet)
+	if cmd != nil {
+		cmd.ID = id
+	}
+	return cmd
+}
+
+// negotiate settles the protocol version spoken on this connection, telling the client when his own is too old.
+func (this *Player) negotiate(id string, requested int) (int, bool) {
+	version, ok := protocol.Negotiate(requested)
+	if !ok {
+		log.Warning("Player %s speaks an unsupported protocol version %d.", this.uuid, requested)
+		this.commandQueue <- tx.Reply(id, tx.Error{
+			Code:   426,
+			Reason: fmt.Sprintf("Protocol version %d is not supported anymore, please upgrade.", requested),
+			Field:  "version",
+		})
+		return 0, false
+	}
+	this.protocolVersion = version
+	return version, true
+}
+
+func (this *Player) commandFromPacket(id string, packet protocol.Packet) *rx.Base {
+	switch pkt := packet.(type) {
 	// Those commands need to pass through the hub.
-	case "Register":
-		return rx.Wrap(this, rx.Register{Username: rec.String("username")})
+	case *protocol.Register:
+		version, ok := this.negotiate(id, pkt.Version)
+		if !ok {
+			break
+		}
+		return rx.Wrap(this, rx.Register{Username: pkt.Username, Password: pkt.Password, Version: version})
+
+	// User wants to log into his account.
+	case *protocol.Login:
+		version, ok := this.negotiate(id, pkt.Version)
+		if !ok {
+			break
+		}
+		return rx.Wrap(this, rx.Login{Username: pkt.Username, Password: pkt.Password, Version: version})
+
+	// User lost his connection and wants his session back.
+	case *protocol.Resume:
+		if _, ok := this.negotiate(id, pkt.Version); !ok {
+			break
+		}
+		return rx.Wrap(this, rx.Resume{Token: pkt.Token})
+
+	// User wants to say something.
+	case *protocol.ChatSend:
+		return rx.Wrap(this, rx.ChatSend{Channel: pkt.Channel, To: pkt.To, Text: pkt.Text})
+
+	// User wants to be matched with other players.
+	case *protocol.MatchmakingJoin:
+		return rx.Wrap(this, rx.MatchmakingJoin{Players: *pkt.Players, Difficulty: pkt.Difficulty})
+
+	// User doesn't want to be matched anymore.
+	case *protocol.MatchmakingLeave:
+		return rx.Wrap(this, rx.MatchmakingLeave{})
 
 	// User wants a list of existing combats.
-	case "CombatList":
-		return rx.Wrap(this, rx.CombatList{})
+	case *protocol.CombatList:
+		return rx.Wrap(this, rx.CombatList{Running: pkt.Running})
+
+	// User wants to create a combat.
+	case *protocol.CombatCreate:
+		maxPlayers := *pkt.MinPlayers
+		if pkt.MaxPlayers != nil {
+			maxPlayers = *pkt.MaxPlayers
+		}
+		turnDuration := *config.TurnDuration
+		if pkt.TurnDuration != nil {
+			turnDuration = time.Duration(*pkt.TurnDuration) * time.Second
+		}
+		countdown := time.Duration(0)
+		if pkt.Countdown != nil {
+			countdown = time.Duration(*pkt.Countdown) * time.Second
+		}
+		timeoutAction := pkt.TimeoutAction
+		if timeoutAction == "" {
+			timeoutAction = *config.TimeoutAction
+		}
+		return rx.Wrap(this, rx.CombatCreate{
+			MinPlayers:    *pkt.MinPlayers,
+			MaxPlayers:    maxPlayers,
+			TurnDuration:  turnDuration,
+			Countdown:     countdown,
+			TimeoutAction: timeoutAction,
+			Difficulty:    pkt.Difficulty,
+			Profile:       pkt.Profile,
+			Visibility:    pkt.Visibility,
+			Password:      pkt.Password,
+		})
 
 	// User wants to join the combat.
-	case "CombatJoin":
+	case *protocol.CombatJoin:
 		return rx.Wrap(this, rx.CombatJoin{
-			UUID: rec.String("uuid"),
+			UUID:     pkt.UUID,
+			Code:     pkt.Code,
+			Password: pkt.Password,
+		})
+
+	// User wants another player to join his combat.
+	case *protocol.CombatInvite:
+		return rx.Wrap(this, rx.CombatInvite{Username: pkt.Username})
+
+	// User accepts or declines an invitation.
+	case *protocol.CombatInviteAnswer:
+		return rx.Wrap(this, rx.CombatInviteAnswer{Code: pkt.Code, Accept: *pkt.Accept})
+
+	// User doesn't want an invite code to be usable anymore.
+	case *protocol.CombatInviteRevoke:
+		return rx.Wrap(this, rx.CombatInviteRevoke{Code: pkt.Code})
+
+	// User wants a new code to share.
+	case *protocol.CombatInviteCode:
+		return rx.Wrap(this, rx.CombatInviteCode{})
+
+	// User wants to watch a combat.
+	case *protocol.CombatSpectate:
+		return rx.Wrap(this, rx.CombatSpectate{
+			UUID: pkt.UUID,
+		})
+
+	// User wants to watch a recorded combat.
+	case *protocol.CombatReplay:
+		return rx.Wrap(this, rx.CombatReplay{
+			ID: pkt.Replay,
 		})
 
 	// User wants to play his turn.
-	case "CombatPlayTurn":
-		if !this.IsInCombat() {
-			log.Warning("Player %s requested to play a turn combat, but he is not in a combat.", this.uuid)
-			this.commandQueue <- tx.Wrap(tx.Error{
+	case *protocol.CombatPlayTurn:
+		combat := this.Combat()
+		if combat == nil {
+			log.Warning("Player %s requested to play a turn, but he is not in a combat.", this.uuid)
+			this.commandQueue <- tx.Reply(id, tx.Error{
 				Code:   422,
-				Reason: "You cannot leave a combat while not participating a combat.",
+				Reason: "You cannot play a turn while not participating a combat.",
 			})
 			break
 		}
-		this.mutex.Lock()
-		defer this.mutex.Unlock()
-		this.combat.Notify(cbt.Wrap(cbt.PlayTurn{
+		combat.Notify(cbt.Reply(id, cbt.PlayTurn{
 			Player:      this,
-			PieceIndex:  rec.Int("pieceIndex"),
-			Rotation:    logic.NewQuaternionFromMap(rec.Map("rotation")),
-			Translation: logic.NewVectorFromMap(rec.Map("translation")),
+			PieceIndex:  *pkt.PieceIndex,
+			Rotation:    pkt.Rotation.ToQuaternion(),
+			Translation: pkt.Translation.ToVector(),
 		}))
 
 	// User wants to leave the combat.
-	case "CombatLeave":
+	case *protocol.CombatLeave:
 		this.LeaveCombat()
 		break
 
 	// User wants to vote against another player.
-	case "CombatVote":
-		if !this.IsInCombat() {
+	case *protocol.CombatVote:
+		combat := this.Combat()
+		if combat == nil {
 			log.Warning("Player %s is trying to vote, but he is not in a combat", this.uuid)
-			this.commandQueue <- tx.Wrap(tx.Error{
+			this.commandQueue <- tx.Reply(id, tx.Error{
 				Code:   422,
 				Reason: "You cannot vote while not participating a combat.",
 			})
 			break
 		}
-		// TODO: Notify the combat about the vote.
+		combat.Notify(cbt.Reply(id, cbt.Vote{
+			Player:   this,
+			PlayerID: pkt.PlayerUUID,
+		}))
+
+	// User is ready to start, or not anymore.
+	case *protocol.CombatReady:
+		combat := this.Combat()
+		if combat == nil {
+			this.commandQueue <- tx.Reply(id, tx.Error{
+				Code:   422,
+				Reason: "You cannot get ready while not participating a combat.",
+			})
+			break
+		}
+		combat.Notify(cbt.Reply(id, cbt.Ready{
+			Player: this,
+			Ready:  *pkt.Ready,
+		}))
+
+	// User wants to start the combat he is hosting.
+	case *protocol.CombatLaunch:
+		combat := this.Combat()
+		if combat == nil {
+			this.commandQueue <- tx.Reply(id, tx.Error{
+				Code:   422,
+				Reason: "You cannot start a combat while not participating a combat.",
+			})
+			break
+		}
+		combat.Notify(cbt.Reply(id, cbt.Launch{Player: this}))
 
 	default:
-		log.Warning("Player %s sent an unhandled command type: %s.", this.uuid, rec)
-		this.commandQueue <- tx.Wrap(tx.Error{
+		log.Warning("Player %s sent an unhandled command type: %T.", this.uuid, pkt)
+		this.commandQueue <- tx.Reply(id, tx.Error{
 			Code:   422,
 			Reason: "The command you sent could not be understood by the server.",
 		})
@@ -170,29 +470,29 @@ func (this *Player) CommandFromPacket(line []byte) *rx.Base {
 func (this *Player) readLoop(hubQueue chan<- *rx.Base) {
 	// Whenever the read loop exits, unregister the player from the hub and close the connection.
 	defer func() {
+		// The connection might have been resumed into another player in the meantime.
+		player := this.session()
 		// Signal our write queue to exit.
-		this.exit <- true
+		player.stopWriting()
 		// Signal our hub to stop handling this client.
-		hubQueue <- rx.Wrap(this, rx.Unregister{})
+		hubQueue <- rx.Wrap(player, rx.Unregister{})
 	}()
-	// Prepare our json reader directly from the connection.
-	reader := bufio.NewReader(this.connection)
 	// Loop for every JSON packet received.
 	for {
-		line, _, err := reader.ReadLine()
+		line, err := this.connection.Read()
 		if err != nil {
 			break
 		}
-		if cmd := this.CommandFromPacket(line); cmd != nil {
+		if cmd := this.session().CommandFromPacket(line); cmd != nil {
 			hubQueue <- cmd
 		}
 	}
 }
 
 // WriteLoop pumps messages from the hub to the player.
-func (this *Player) writeLoop() {
+func (this *Player) writeLoop(connection i.Transport, exit <-chan bool, stopped chan<- bool) {
+	defer close(stopped)
 	// Loop until data is ready to be sent.
-	json := json.NewEncoder(this.connection)
 	for {
 		select {
 		// Data is ready to be sent on channel.
@@ -202,18 +502,13 @@ func (this *Player) writeLoop() {
 				return
 			}
 
-			// We got ourself a nice command!
-			if _, err := fmt.Fprintf(this.connection, "%s\r", cmd.Type); err != nil {
-				log.Warning("Failed to send next packet type to player %s: %s", this.uuid, err)
-				return
-			}
-			// Try to send the message, and handle failure.
-			if _, err := fmt.Fprintf(this.connection, "%s\n", json.Encode(cmd.Command)); err != nil {
+			// We got ourself a nice command! Try to send it, and handle failure.
+			if err := connection.Write(cmd); err != nil {
 				log.Warning("Failed to send packet to player %s: %s", this.uuid, err)
 				return
 			}
 		// This player was asked to exit the loop.
-		case <-this.exit:
+		case <-exit:
 			return
 		case <-time.After(1 * time.Second):
 		}
@@ -0,0 +1,356 @@
+package protocol
+
+import (
+	"fmt"
+	"github.com/hickscorp/communitrix-server/chat"
+	"github.com/hickscorp/communitrix-server/gen"
+	"github.com/hickscorp/communitrix-server/logic"
+	"github.com/hickscorp/communitrix-server/util"
+)
+
+// Packet is implemented by every inbound message.
+type Packet interface {
+	Validate() *FieldError // Checks the decoded values.
+}
+
+// Header is shared by all packets.
+type Header struct {
+	Type string `json:"type"`
+	ID   string `json:"id"` // Optional, echoed back in direct replies.
+}
+
+// packets maps packet types to their constructors.
+var packets = map[string]func() Packet{
+	"Register":       func() Packet { return &Register{} },
+	"Login":          func() Packet { return &Login{} },
+	"Resume":         func() Packet { return &Resume{} },
+	"CombatList":     func() Packet { return &CombatList{} },
+	"CombatCreate":   func() Packet { return &CombatCreate{} },
+	"CombatJoin":     func() Packet { return &CombatJoin{} },
+	"CombatPlayTurn": func() Packet { return &CombatPlayTurn{} },
+	"CombatLeave":    func() Packet { return &CombatLeave{} },
+	"CombatVote":     func() Packet { return &CombatVote{} },
+	"CombatReady":    func() Packet { return &CombatReady{} },
+	"CombatLaunch":   func() Packet { return &CombatLaunch{} },
+	"CombatSpectate": func() Packet { return &CombatSpectate{} },
+	"CombatReplay":   func() Packet { return &CombatReplay{} },
+
+	"CombatInvite":       func() Packet { return &CombatInvite{} },
+	"CombatInviteAnswer": func() Packet { return &CombatInviteAnswer{} },
+	"CombatInviteRevoke": func() Packet { return &CombatInviteRevoke{} },
+	"CombatInviteCode":   func() Packet { return &CombatInviteCode{} },
+
+	"ChatSend": func() Packet { return &ChatSend{} },
+
+	"MatchmakingJoin":  func() Packet { return &MatchmakingJoin{} },
+	"MatchmakingLeave": func() Packet { return &MatchmakingLeave{} },
+}
+
+type Register struct {
+	Header
+	Username string `json:"username"`
+	Password string `json:"password"` // Creates an account when given, otherwise the player stays a guest.
+	Version  int    `json:"version"`  // The protocol version the client speaks, if any.
+}
+
+func (this *Register) Validate() *FieldError {
+	if this.Username == "" {
+		return required("username")
+	}
+	return nil
+}
+
+type Login struct {
+	Header
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Version  int    `json:"version"` // The protocol version the client speaks, if any.
+}
+
+func (this *Login) Validate() *FieldError {
+	if this.Username == "" {
+		return required("username")
+	} else if this.Password == "" {
+		return required("password")
+	}
+	return nil
+}
+
+type Resume struct {
+	Header
+	Token   string `json:"token"`
+	Version int    `json:"version"`
+}
+
+func (this *Resume) Validate() *FieldError {
+	if this.Token == "" {
+		return required("token")
+	}
+	return nil
+}
+
+type CombatList struct {
+	Header
+	Running bool `json:"running"` // Whether combats which already started should be listed too.
+}
+
+func (this *CombatList) Validate() *FieldError { return nil }
+
+type CombatCreate struct {
+	Header
+	MinPlayers    *int         `json:"minPlayers"`
+	MaxPlayers    *int         `json:"maxPlayers"`    // Optional, defaults to the minimum.
+	TurnDuration  *int         `json:"turnDuration"`  // Optional, in seconds, zero meaning forever.
+	Countdown     *int         `json:"countdown"`     // Optional, in seconds, how long the lobby waits once everyone is ready. Zero means the creator starts it.
+	TimeoutAction string       `json:"timeoutAction"` // Optional, what happens to idle players once a turn times out.
+	Difficulty    string       `json:"difficulty"`    // Optional, the generation preset to use.
+	Profile       *gen.Profile `json:"profile"`       // Optional, detailed generation settings replacing the difficulty.
+	Visibility    string       `json:"visibility"`    // Optional, public, private or password, defaults to public.
+	Password      string       `json:"password"`      // Required by password-protected combats.
+}
+
+func (this *CombatCreate) Validate() *FieldError {
+	if this.MinPlayers == nil {
+		return required("minPlayers")
+	} else if *this.MinPlayers < 1 || *this.MinPlayers > MaxPlayers {
+		return &FieldError{Path: "minPlayers", Reason: fmt.Sprintf("must be between 1 and %d", MaxPlayers)}
+	} else if this.MaxPlayers != nil && (*this.MaxPlayers < *this.MinPlayers || *this.MaxPlayers > MaxPlayers) {
+		return &FieldError{Path: "maxPlayers", Reason: fmt.Sprintf("must be between %d and %d", *this.MinPlayers, MaxPlayers)}
+	} else if this.TurnDuration != nil && (*this.TurnDuration < 0 || *this.TurnDuration > MaxTurnDuration) {
+		return &FieldError{Path: "turnDuration", Reason: fmt.Sprintf("must be between 0 and %d", MaxTurnDuration)}
+	} else if this.Countdown != nil && (*this.Countdown < 0 || *this.Countdown > MaxCountdown) {
+		return &FieldError{Path: "countdown", Reason: fmt.Sprintf("must be between 0 and %d", MaxCountdown)}
+	} else if this.Difficulty != "" && this.Profile != nil {
+		return &FieldError{Path: "profile", Reason: "cannot be given along with a difficulty"}
+	}
+	return nil
+}
+
+type CombatJoin struct {
+	Header
+	UUID     string `json:"uuid"`
+	Code     string `json:"code"`     // Required by private combats, the UUID can then be omitted.
+	Password string `json:"password"` // Required by password-protected combats.
+}
+
+func (this *CombatJoin) Validate() *FieldError {
+	if this.UUID == "" && this.Code == "" {
+		return required("uuid")
+	}
+	return nil
+}
+
+type CombatInvite struct {
+	Header
+	Username string `json:"username"` // The online player to invite.
+}
+
+func (this *CombatInvite) Validate() *FieldError {
+	if this.Username == "" {
+		return required("username")
+	}
+	return nil
+}
+
+type CombatInviteAnswer struct {
+	Header
+	Code   string `json:"code"`
+	Accept *bool  `json:"accept"`
+}
+
+func (this *CombatInviteAnswer) Validate() *FieldError {
+	if this.Code == "" {
+		return required("code")
+	} else if this.Accept == nil {
+		return required("accept")
+	}
+	return nil
+}
+
+type CombatInviteRevoke struct {
+	Header
+	Code string `json:"code"`
+}
+
+func (this *CombatInviteRevoke) Validate() *FieldError {
+	if this.Code == "" {
+		return required("code")
+	}
+	return nil
+}
+
+type CombatInviteCode struct {
+	Header
+}
+
+func (this *CombatInviteCode) Validate() *FieldError { return nil }
+
+type CombatSpectate struct {
+	Header
+	UUID string `json:"uuid"`
+}
+
+func (this *CombatSpectate) Validate() *FieldError {
+	if this.UUID == "" {
+		return required("uuid")
+	}
+	return nil
+}
+
+type CombatReplay struct {
+	Header
+	Replay string `json:"replay"`
+}
+
+func (this *CombatReplay) Validate() *FieldError {
+	if this.Replay == "" {
+		return required("replay")
+	}
+	return nil
+}
+
+type CombatPlayTurn struct {
+	Header
+	PieceIndex  *int        `json:"pieceIndex"`
+	Rotation    *Quaternion `json:"rotation"`
+	Translation *Vector     `json:"translation"`
+}
+
+func (this *CombatPlayTurn) Validate() *FieldError {
+	if this.PieceIndex == nil {
+		return required("pieceIndex")
+	} else if this.Rotation == nil {
+		return required("rotation")
+	} else if this.Translation == nil {
+		return required("translation")
+	} else if err := this.Rotation.validate("rotation"); err != nil {
+		return err
+	}
+	return this.Translation.validate("translation")
+}
+
+type CombatLeave struct {
+	Header
+}
+
+func (this *CombatLeave) Validate() *FieldError { return nil }
+
+type CombatVote struct {
+	Header
+	PlayerUUID string `json:"playerUUID"`
+}
+
+func (this *CombatVote) Validate() *FieldError {
+	if this.PlayerUUID == "" {
+		return required("playerUUID")
+	}
+	return nil
+}
+
+type CombatReady struct {
+	Header
+	Ready *bool `json:"ready"`
+}
+
+func (this *CombatReady) Validate() *FieldError {
+	if this.Ready == nil {
+		return required("ready")
+	}
+	return nil
+}
+
+type CombatLaunch struct {
+	Header
+}
+
+func (this *CombatLaunch) Validate() *FieldError { return nil }
+
+type ChatSend struct {
+	Header
+	Channel string `json:"channel"` // Where to send the message (global, combat, direct).
+	To      string `json:"to"`      // The username of the recipient, required by direct messages.
+	Text    string `json:"text"`
+}
+
+func (this *ChatSend) Validate() *FieldError {
+	if this.Channel == "" {
+		return required("channel")
+	} else if !chat.IsChannel(this.Channel) {
+		return &FieldError{Path: "channel", Reason: fmt.Sprintf("unknown channel %q", this.Channel)}
+	} else if this.Channel == chat.ChannelDirect && this.To == "" {
+		return required("to")
+	} else if this.Text == "" {
+		return required("text")
+	}
+	return nil
+}
+
+type MatchmakingJoin struct {
+	Header
+	Players    *int   `json:"players"`    // How many players the combat should have.
+	Difficulty string `json:"difficulty"` // Optional, defaults to normal.
+}
+
+func (this *MatchmakingJoin) Validate() *FieldError {
+	if this.Players == nil {
+		return required("players")
+	} else if *this.Players < 1 || *this.Players > MaxPlayers {
+		return &FieldError{Path: "players", Reason: fmt.Sprintf("must be between 1 and %d", MaxPlayers)}
+	}
+	return nil
+}
+
+type MatchmakingLeave struct {
+	Header
+}
+
+func (this *MatchmakingLeave) Validate() *FieldError { return nil }
+
+// Vector is the wire representation of a logic.Vector. Components may be sent as floats, they are rounded.
+type Vector struct {
+	X *float64 `json:"x"`
+	Y *float64 `json:"y"`
+	Z *float64 `json:"z"`
+}
+
+func (this *Vector) validate(path string) *FieldError {
+	if this.X == nil {
+		return required(path + ".x")
+	} else if this.Y == nil {
+		return required(path + ".y")
+	} else if this.Z == nil {
+		return required(path + ".z")
+	}
+	return nil
+}
+
+// ToVector converts a validated wire vector.
+func (this *Vector) ToVector() *logic.Vector {
+	return logic.NewVectorFromValues(util.QuickIntRound(*this.X), util.QuickIntRound(*this.Y), util.QuickIntRound(*this.Z))
+}
+
+// Quaternion is the wire representation of a logic.Quaternion.
+type Quaternion struct {
+	X *float64 `json:"x"`
+	Y *float64 `json:"y"`
+	Z *float64 `json:"z"`
+	W *float64 `json:"w"`
+}
+
+func (this *Quaternion) validate(path string) *FieldError {
+	if this.X == nil {
+		return required(path + ".x")
+	} else if this.Y == nil {
+		return required(path + ".y")
+	} else if this.Z == nil {
+		return required(path + ".z")
+	} else if this.W == nil {
+		return required(path + ".w")
+	}
+	return nil
+}
+
+// ToQuaternion converts a validated wire quaternion.
+func (this *Quaternion) ToQuaternion() *logic.Quaternion {
+	return &logic.Quaternion{X: *this.X, Y: *this.Y, Z: *this.Z, W: *this.W}
+}
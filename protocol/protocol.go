@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	LegacyVersion  = 1 // Clients not telling which version they speak. Unknown fields are ignored.
+	CurrentVersion = 2 // Unknown fields are refused.
+	MinVersion     = LegacyVersion
+
+	MaxPlayers      = 8       // The maximum number of players a combat can have.
+	MaxTurnDuration = 10 * 60 // The longest turn a combat can have, in seconds.
+	MaxCountdown    = 60      // The longest a lobby can wait before starting, in seconds.
+)
+
+// Negotiate picks the protocol version to use with a client asking for a given version, zero meaning he didn't ask.
+func Negotiate(requested int) (int, bool) {
+	switch {
+	case requested == 0:
+		return LegacyVersion, true
+	case requested < MinVersion:
+		return 0, false
+	case requested > CurrentVersion:
+		return CurrentVersion, true
+	}
+	return requested, true
+}
+
+// FieldError describes why a packet was refused, and which field caused it.
+type FieldError struct {
+	Path   string // The dotted path to the faulty field, empty when the packet as a whole is faulty.
+	Reason string
+}
+
+func (this *FieldError) Error() string {
+	if this.Path == "" {
+		return this.Reason
+	}
+	return fmt.Sprintf("%s: %s", this.Path, this.Reason)
+}
+
+func required(path string) *FieldError {
+	return &FieldError{Path: path, Reason: "is required"}
+}
+
+// Decode turns a raw inbound packet into its typed counterpart, following the rules of a given protocol version.
+func Decode(data []byte, version int) (Packet, *FieldError) {
+	var header Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fieldErrorFrom(err)
+	}
+	if header.Type == "" {
+		return nil, required("type")
+	}
+	factory, ok := packets[header.Type]
+	if !ok {
+		return nil, &FieldError{Path: "type", Reason: fmt.Sprintf("unknown packet type %q", header.Type)}
+	}
+	packet := factory()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if version >= CurrentVersion {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(packet); err != nil {
+		return nil, fieldErrorFrom(err)
+	}
+	if err := packet.Validate(); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// RequestID extracts the request identifier of a packet, even an invalid one, whenever possible.
+func RequestID(data []byte) string {
+	var header struct {
+		ID interface{} `json:"id"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return ""
+	}
+	if id, ok := header.ID.(string); ok {
+		return id
+	}
+	return ""
+}
+
+// fieldErrorFrom converts errors coming from the json package.
+func fieldErrorFrom(err error) *FieldError {
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		return &FieldError{Path: e.Field, Reason: fmt.Sprintf("must be of type %s", e.Type)}
+	case *json.SyntaxError:
+		return &FieldError{Reason: fmt.Sprintf("malformed JSON at offset %d", e.Offset)}
+	}
+	// There is no dedicated error type for unknown fields.
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		return &FieldError{Path: strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`), Reason: "is unknown"}
+	}
+	return &FieldError{Reason: err.Error()}
+}
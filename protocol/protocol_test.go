@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		version int
+		path    string // The faulty field.
+		reason  string // Part of the expected reason.
+	}{
+		{"malformed", `{"type": "Register"`, CurrentVersion, "", "malformed JSON"},
+		{"not an object", `["Register"]`, CurrentVersion, "", "must be of type"},
+		{"no type", `{"username": "bob"}`, CurrentVersion, "type", "is required"},
+		{"unknown type", `{"type": "Teleport"}`, CurrentVersion, "type", `unknown packet type "Teleport"`},
+		{"type of the wrong type", `{"type": 5}`, CurrentVersion, "type", "must be of type string"},
+		{"id of the wrong type", `{"type": "CombatLeave", "id": 5}`, CurrentVersion, "id", "must be of type string"},
+		{"string instead of a number", `{"type": "CombatPlayTurn", "pieceIndex": "1"}`, CurrentVersion, "pieceIndex", "must be of type int"},
+		{"number instead of a string", `{"type": "Register", "username": 12}`, CurrentVersion, "username", "must be of type string"},
+		{"number instead of a boolean", `{"type": "CombatReady", "ready": 1}`, CurrentVersion, "ready", "must be of type bool"},
+		{"nested field of the wrong type", `{"type": "CombatPlayTurn", "pieceIndex": 1, "rotation": {"x": "a"}}`, CurrentVersion, "rotation.x", "must be of type float64"},
+		{"unknown field", `{"type": "Register", "username": "bob", "colour": "red"}`, CurrentVersion, "colour", "is unknown"},
+		{"missing field", `{"type": "Register"}`, LegacyVersion, "username", "is required"},
+		{"missing nested field", `{"type": "CombatPlayTurn", "pieceIndex": 1, "translation": {"x": 0, "y": 0, "z": 0}}`, CurrentVersion, "rotation", "is required"},
+	}
+	for _, c := range cases {
+		packet, err := Decode([]byte(c.data), c.version)
+		if err == nil {
+			t.Errorf("%s: decoded into %#v, want an error", c.name, packet)
+			continue
+		}
+		if err.Path != c.path || !strings.Contains(err.Reason, c.reason) {
+			t.Errorf("%s: got %q on %q, want %q on %q", c.name, err.Reason, err.Path, c.reason, c.path)
+		}
+	}
+}
+
+func TestDecodeVersions(t *testing.T) {
+	data := []byte(`{"type": "Register", "id": "r1", "username": "bob", "colour": "red"}`)
+	// Legacy clients may send fields the server doesn't know about.
+	packet, err := Decode(data, LegacyVersion)
+	if err != nil {
+		t.Fatalf("legacy decoding failed: %s", err)
+	}
+	register, ok := packet.(*Register)
+	if !ok || register.Username != "bob" || register.ID != "r1" {
+		t.Errorf("legacy decoding gave %#v", packet)
+	}
+	if _, err := Decode(data, CurrentVersion); err == nil {
+		t.Error("unknown fields should be refused by the current version")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	cases := map[string]string{
+		`{"type": "Register", "id": "r1"}`:    "r1",
+		`{"type": "Teleport", "id": "r2"}`:    "r2", // Unknown packets still get their errors correlated.
+		`{"type": "Register", "id": 3}`:       "",
+		`{"type": "Register"}`:                "",
+		`{"type": "Register", "id": "r4"`:     "",
+		`{"type": "Register", "id": ["r5"]}`:  "",
+		`{"type": "Register", "id": "r6"} {}`: "",
+	}
+	for data, want := range cases {
+		if got := RequestID([]byte(data)); got != want {
+			t.Errorf("%s: got request identifier %q, want %q", data, got, want)
+		}
+	}
+}
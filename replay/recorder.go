@@ -0,0 +1,44 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Recorder appends the entries of a single combat to its replay log.
+type Recorder struct {
+	id      string
+	file    *os.File
+	encoder *json.Encoder
+	started time.Time
+}
+
+// NewRecorder creates the replay log of a combat in the given directory.
+func NewRecorder(dir, combatUUID string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Combat identifiers start over whenever the server restarts.
+	started := time.Now()
+	id := fmt.Sprintf("%s-%s", started.Format("20060102-150405"), combatUUID)
+	file, err := os.OpenFile(path(dir, id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{id: id, file: file, encoder: json.NewEncoder(file), started: started}, nil
+}
+
+// ID gives the identifier allowing to load this replay back.
+func (this *Recorder) ID() string { return this.id }
+
+// Record appends an entry to the replay log, stamping it with the time elapsed since the recording started.
+func (this *Recorder) Record(entry *Entry) error {
+	entry.Elapsed = time.Since(this.started)
+	return this.encoder.Encode(entry)
+}
+
+func (this *Recorder) Close() error {
+	return this.file.Close()
+}
@@ -0,0 +1,105 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hickscorp/communitrix-server/gen"
+	"github.com/hickscorp/communitrix-server/logic"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("replay not found")
+	ErrInvalidID = errors.New("invalid replay identifier")
+	ErrCorrupted = errors.New("corrupted replay")
+)
+
+// Entry types, in the order they usually appear in a replay.
+const (
+	EntryStart       = "Start"       // The combat started, with its rules and generated data.
+	EntryPlayTurn    = "PlayTurn"    // A player sent a move.
+	EntryVote        = "Vote"        // A player voted against another one.
+	EntryLeave       = "Leave"       // A player left the combat.
+	EntryTurnTimeout = "TurnTimeout" // The current turn deadline was reached.
+	EntryEnd         = "End"         // The last turn was played.
+)
+
+// Replay identifiers are file names without their extension, and cannot point outside of the replay directory.
+var validID = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// Player identifies a player taking part in a recorded combat.
+type Player struct {
+	UUID     string `json:"uuid"`
+	Username string `json:"username"`
+	Account  string `json:"account,omitempty"` // The account the player was logged into, guests having none.
+}
+
+// Rules holds the combat settings having an influence over its outcome.
+type Rules struct {
+	VoteMajority     float64                `json:"voteMajority"`
+	VoteOutcome      string                 `json:"voteOutcome"`
+	SilhouettePolicy logic.SilhouettePolicy `json:"silhouettePolicy"`
+	TimeoutAction    string                 `json:"timeoutAction"`
+}
+
+// Entry is a single line of a replay log.
+type Entry struct {
+	Type    string        `json:"type"`    // The kind of entry.
+	Elapsed time.Duration `json:"elapsed"` // Time since the combat started.
+	Turn    int           `json:"turn"`    // The turn during which this entry was recorded.
+	// Start entries only.
+	Seed    int64        `json:"seed,omitempty"`    // The random seed used for generation.
+	Profile *gen.Profile `json:"profile,omitempty"` // How the target and pieces were generated.
+	Rules   *Rules       `json:"rules,omitempty"`   // The combat settings.
+	Players []Player     `json:"players,omitempty"` // The players, ordered by their index.
+	Target  *logic.Piece `json:"target,omitempty"`  // The objective for all players.
+	Pieces  logic.Pieces `json:"pieces,omitempty"`  // The pieces all players are given.
+	Units   logic.Units  `json:"units,omitempty"`   // The initial state of each unit.
+	// Who may watch the replay: anyone when public, otherwise the owner and players only.
+	Visibility string `json:"visibility,omitempty"`
+	Owner      string `json:"owner,omitempty"` // The account of the player who created the combat, if he was logged in and still in.
+	// Player entries only.
+	PlayerUUID  string            `json:"playerUUID,omitempty"`  // The player this entry is about.
+	PieceIndex  int               `json:"pieceIndex,omitempty"`  // The piece played.
+	Rotation    *logic.Quaternion `json:"rotation,omitempty"`    // The rotation the piece was played with.
+	Translation *logic.Vector     `json:"translation,omitempty"` // The translation the piece was played with.
+	Against     string            `json:"against,omitempty"`     // The player voted against.
+}
+
+func path(dir, id string) string {
+	return filepath.Join(dir, id+".jsonl")
+}
+
+// Load reads all entries of a replay. The first entry is always the start of the combat.
+func Load(dir, id string) ([]*Entry, error) {
+	if !validID.MatchString(id) {
+		return nil, ErrInvalidID
+	}
+	file, err := os.Open(path(dir, id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]*Entry, 0)
+	decoder := json.NewDecoder(file)
+	for {
+		entry := &Entry{}
+		if err := decoder.Decode(entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, ErrCorrupted
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 || entries[0].Type != EntryStart || entries[0].Rules == nil {
+		return nil, ErrCorrupted
+	}
+	return entries, nil
+}
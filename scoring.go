@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/hickscorp/communitrix-server/cmd/tx"
+	"github.com/hickscorp/communitrix-server/logic"
+	"sort"
+)
+
+// Score compares every unit to the target, and credits each player with his share of the units he played on.
+func (this *Combat) Score() ([]*logic.Score, []tx.CombatResult) {
+	scores := make([]*logic.Score, len(this.state.units))
+	credits := make(map[string]int)
+	for idx, unit := range this.state.units {
+		scores[idx] = unit.Compare(this.state.target)
+		log.Debug("  - Unit %d: %+v", idx, scores[idx])
+		// Each player gets a share of the unit score, proportional to the cells he brought in.
+		playerCells, totalCells := make(map[string]int), 0
+		for uuid, ids := range unit.Moves {
+			for _, id := range ids {
+				playerCells[uuid] += len(this.state.pieces[id].Content)
+			}
+			totalCells += playerCells[uuid]
+		}
+		if totalCells == 0 {
+			continue
+		}
+		for uuid, cells := range playerCells {
+			credits[uuid] += scores[idx].Value() * cells / totalCells
+		}
+	}
+
+	results := make([]tx.CombatResult, 0, len(this.players))
+	for uuid, player := range this.players {
+		penalty := this.state.penalties[uuid]
+		results = append(results, tx.CombatResult{
+			PlayerUUID: uuid,
+			Username:   player.Username(),
+			Score:      credits[uuid] - penalty,
+			Penalty:    penalty,
+		})
+	}
+	sort.Sort(combatResultsByScore(results))
+	// Players with identical scores share the same rank.
+	for idx := range results {
+		if idx > 0 && results[idx].Score == results[idx-1].Score {
+			results[idx].Rank = results[idx-1].Rank
+		} else {
+			results[idx].Rank = idx + 1
+		}
+	}
+	return scores, results
+}
+
+// experienceFor computes the XP a player earns given his result in a combat.
+func experienceFor(result tx.CombatResult, playerCount int) int {
+	xp := 10 + 10*(playerCount-result.Rank)
+	if result.Score > 0 {
+		xp += result.Score
+	}
+	return xp
+}
+
+type combatResultsByScore []tx.CombatResult
+
+func (this combatResultsByScore) Len() int           { return len(this) }
+func (this combatResultsByScore) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
+func (this combatResultsByScore) Less(i, j int) bool { return this[i].Score > this[j].Score }
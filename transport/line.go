@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hickscorp/communitrix-server/cmd/tx"
+	"net"
+)
+
+// MaxPacketSize is the size of the largest packet a client may send, whatever its transport.
+const MaxPacketSize = 4096
+
+var ErrPacketTooLarge = errors.New("packet too large")
+
+// Line is the raw TCP transport. Inbound packets are JSON objects, one per line. Outbound commands are
+// made of their type followed by a carriage return, then of their JSON body followed by a line feed.
+// Replies carry the request identifier as an "id" member of their body.
+type Line struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	encoder *json.Encoder
+}
+
+// NewLine is the Line default constructor.
+func NewLine(conn net.Conn) *Line {
+	return &Line{
+		conn:    conn,
+		reader:  bufio.NewReaderSize(conn, MaxPacketSize),
+		encoder: json.NewEncoder(conn),
+	}
+}
+
+func (this *Line) Read() ([]byte, error) {
+	line, isPrefix, err := this.reader.ReadLine()
+	if err == nil && isPrefix {
+		return nil, ErrPacketTooLarge
+	}
+	return line, err
+}
+
+func (this *Line) Write(cmd *tx.Base) error {
+	if _, err := fmt.Fprintf(this.conn, "%s\r", cmd.Type); err != nil {
+		return err
+	}
+	// The encoder terminates the body with a line feed by itself.
+	if cmd.ID == "" {
+		return this.encoder.Encode(cmd.Command)
+	}
+	body, err := json.Marshal(cmd.Command)
+	if err != nil {
+		return err
+	}
+	id, _ := json.Marshal(cmd.ID)
+	// Commands are always objects, so the identifier can be injected as their first member.
+	if string(body) == "{}" {
+		body = []byte(fmt.Sprintf(`{"id":%s}`, id))
+	} else {
+		body = []byte(fmt.Sprintf(`{"id":%s,%s`, id, body[1:]))
+	}
+	_, err = fmt.Fprintf(this.conn, "%s\n", body)
+	return err
+}
+
+func (this *Line) Close() error {
+	return this.conn.Close()
+}
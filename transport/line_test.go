@@ -0,0 +1,27 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestLineReadLimitsPacketSize(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	line := NewLine(server)
+	defer line.Close()
+
+	fits := bytes.Repeat([]byte("a"), MaxPacketSize-1)
+	tooLarge := bytes.Repeat([]byte("b"), MaxPacketSize+1)
+	go func() {
+		client.Write(append(fits, '\n'))
+		client.Write(append(tooLarge, '\n'))
+	}()
+	if got, err := line.Read(); err != nil || !bytes.Equal(got, fits) {
+		t.Errorf("reading a packet just under the limit gave %d bytes and %v", len(got), err)
+	}
+	if _, err := line.Read(); err != ErrPacketTooLarge {
+		t.Errorf("reading a packet over the limit gave %v, want %v", err, ErrPacketTooLarge)
+	}
+}
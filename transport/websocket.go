@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hickscorp/communitrix-server/cmd/tx"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WebSocket is the transport used by browser clients. Inbound packets are JSON objects, one per text
+// message. Outbound commands are sent as text messages holding both their type and their body.
+type WebSocket struct {
+	conn *websocket.Conn
+}
+
+// Upgrade performs the WebSocket handshake over an HTTP request. Browsers must be served from one of the
+// given origins, "*" allowing any of them. Without origins, only pages served by this very host are allowed.
+func Upgrade(w http.ResponseWriter, r *http.Request, origins []string) (*WebSocket, error) {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin:     func(r *http.Request) bool { return originAllowed(r, origins) },
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(MaxPacketSize)
+	return &WebSocket{conn: conn}, nil
+}
+
+// originAllowed tells whether the page a request comes from may connect. Non-browser clients don't send any origin.
+func originAllowed(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if len(origins) == 0 {
+		return strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) || strings.EqualFold(allowed, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *WebSocket) Read() ([]byte, error) {
+	_, data, err := this.conn.ReadMessage()
+	return data, err
+}
+
+func (this *WebSocket) Write(cmd *tx.Base) error {
+	return this.conn.WriteJSON(cmd)
+}
+
+func (this *WebSocket) Close() error {
+	return this.conn.Close()
+}